@@ -0,0 +1,222 @@
+package main
+
+import (
+	"diesgen/api"
+	"diesgen/metrics"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tenantStatus is what the loop of a tenant is doing, reported by /status.
+type tenantStatus struct {
+	Name        string     `json:"name,omitempty"`
+	Running     bool       `json:"running"`
+	LastRun     *time.Time `json:"lastRun,omitempty"`
+	LastResult  string     `json:"lastResult,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	NextRun     *time.Time `json:"nextRun,omitempty"`
+	// ConfigError is set while the config file fails to load or validate
+	ConfigError string `json:"configError,omitempty"`
+	// TokenValid is nil until a sync got an answer from the bank
+	TokenValid *bool `json:"tokenValid,omitempty"`
+}
+
+// serviceStatus is the body of /status.
+type serviceStatus struct {
+	State   string         `json:"state"`
+	Paused  bool           `json:"paused"`
+	Tenants []tenantStatus `json:"tenants"`
+}
+
+// adminHandler serves the local admin api: /healthz, /readyz, /status, /metrics and
+// POST /sync, /pause and /resume.
+func (m *DiesGenService) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", m.handleHealth)
+	mux.HandleFunc("/readyz", m.handleReady)
+	mux.HandleFunc("/status", m.handleStatus)
+	mux.HandleFunc("/sync", m.handleSync)
+	mux.HandleFunc("/pause", m.handlePause)
+	mux.HandleFunc("/resume", m.handleResume)
+	mux.Handle("/metrics", metrics.Default)
+	return mux
+}
+
+// handleHealth answers while the service runs.
+func (m *DiesGenService) handleHealth(w http.ResponseWriter, _ *http.Request) {
+	_, _ = fmt.Fprintln(w, "ok")
+}
+
+// handleReady answers 200 when the config of every tenant is valid and its token was accepted by the bank.
+func (m *DiesGenService) handleReady(w http.ResponseWriter, _ *http.Request) {
+	var problems []string
+	for _, t := range m.tenants {
+		s := t.snapshot()
+		name := s.Name
+		if name == "" {
+			name = t.ConfigPath
+		}
+		switch {
+		case s.ConfigError != "":
+			problems = append(problems, fmt.Sprintf("%s: %s", name, s.ConfigError))
+		case s.TokenValid == nil:
+			problems = append(problems, fmt.Sprintf("%s: token not checked yet", name))
+		case !*s.TokenValid:
+			problems = append(problems, fmt.Sprintf("%s: token rejected by monobank", name))
+		}
+	}
+
+	if len(problems) > 0 {
+		http.Error(w, strings.Join(problems, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = fmt.Fprintln(w, "ready")
+}
+
+func (m *DiesGenService) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	state := m.state
+	m.mu.Unlock()
+
+	status := serviceStatus{State: state.String(), Paused: state == StatePaused}
+	for _, t := range m.tenants {
+		status.Tenants = append(status.Tenants, t.snapshot())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(status)
+}
+
+// handleSync requests an immediate sync of every tenant or the one given with ?tenant=.
+func (m *DiesGenService) handleSync(w http.ResponseWriter, r *http.Request) {
+	if !postOnly(w, r) {
+		return
+	}
+	if m.paused() {
+		http.Error(w, "service is paused, POST /resume first", http.StatusConflict)
+		return
+	}
+
+	name := r.URL.Query().Get("tenant")
+	if !m.sync(name) {
+		http.Error(w, fmt.Sprintf("tenant %s not found", name), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = fmt.Fprintln(w, "sync requested")
+}
+
+// handlePause pauses the service until /resume, also across restarts.
+func (m *DiesGenService) handlePause(w http.ResponseWriter, r *http.Request) {
+	if !postOnly(w, r) {
+		return
+	}
+	m.control(w, m.pause(true), "paused")
+}
+
+// handleResume resumes the service paused through the admin api or the service manager.
+func (m *DiesGenService) handleResume(w http.ResponseWriter, r *http.Request) {
+	if !postOnly(w, r) {
+		return
+	}
+	m.control(w, m.resume(), "resumed")
+}
+
+// control answers a pause or resume request.
+func (m *DiesGenService) control(w http.ResponseWriter, err error, done string) {
+	switch {
+	case err == nil:
+		_, _ = fmt.Fprintln(w, done)
+	case errors.Is(err, errNotRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		log.Error(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// postOnly answers requests other than POST with 405 and reports whether r is a POST.
+func postOnly(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodPost)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+func (t *tenant) snapshot() tenantStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status
+}
+
+func (t *tenant) setRunning() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.Running = true
+}
+
+// authorized records that the bank accepted the token.
+func (t *tenant) authorized() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	valid := true
+	t.status.TokenValid = &valid
+}
+
+// finished records the result of a sync.
+func (t *tenant) finished(err error) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Running = false
+	t.status.LastRun = &now
+	if err != nil {
+		t.status.LastResult, t.status.LastError = "error", err.Error()
+		if errors.Is(err, api.ErrUnauthorized) {
+			valid := false
+			t.status.TokenValid = &valid
+		}
+		return
+	}
+
+	valid := true
+	t.status.LastResult, t.status.LastError = "success", ""
+	t.status.LastSuccess = &now
+	t.status.TokenValid = &valid
+}
+
+func (t *tenant) setConfigError(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.ConfigError = ""
+	if err != nil {
+		t.status.ConfigError = err.Error()
+	}
+}
+
+// scheduleNext records the first tick the jars are polled on, there is none while paused.
+func (t *tenant) scheduleNext() {
+	var next *time.Time
+	if !t.paused() {
+		tick := t.nextTick
+		for !t.pollDue(tick) {
+			tick = t.schedule.Next(tick)
+		}
+		next = &tick
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.NextRun = next
+}
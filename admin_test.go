@@ -0,0 +1,161 @@
+package main
+
+import (
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/service"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newAdminService returns a running service of one tenant without its loops.
+func newAdminService(t *testing.T) *DiesGenService {
+	dir := t.TempDir()
+	m := NewDiesGenService(service.Options{Tenant: "house", ConfigPath: filepath.Join(dir, "config.json")})
+	m.PauseFile = filepath.Join(dir, "paused")
+	m.report = func(State) {}
+	m.setState(StateRunning)
+	return m
+}
+
+func serveAdmin(m *DiesGenService, method string, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	m.adminHandler().ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func TestAdminHealth(t *testing.T) {
+	m := newAdminService(t)
+	w := serveAdmin(m, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ok\n", w.Body.String())
+}
+
+func TestAdminReady(t *testing.T) {
+	m := newAdminService(t)
+	tn := m.tenants[0]
+
+	tn.setConfigError(errors.New("xToken is empty"))
+	w := serveAdmin(m, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "house: xToken is empty")
+
+	tn.setConfigError(nil)
+	w = serveAdmin(m, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "house: token not checked yet")
+
+	tn.finished(fmt.Errorf("client info: %w", api.ErrUnauthorized))
+	w = serveAdmin(m, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "house: token rejected by monobank")
+
+	tn.finished(nil)
+	w = serveAdmin(m, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "ready\n", w.Body.String())
+
+	// a sync failing after the bank accepted the token proves it
+	_, o := newTestTenant(t)
+	c, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	c.JarName = "Starlink"
+	require.NoError(t, config.SetConfig(o.ConfigPath, *c))
+	m = NewDiesGenService(o)
+	m.PauseFile = filepath.Join(t.TempDir(), "paused")
+	tn = m.tenants[0]
+	startService(t, m)
+
+	require.Eventually(t, func() bool { return tn.snapshot().LastRun != nil }, waitFor, tick)
+	s := tn.snapshot()
+	assert.Equal(t, "error", s.LastResult)
+	assert.Contains(t, s.LastError, "jar not found")
+	w = serveAdmin(m, http.MethodGet, "/readyz")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestAdminStatus(t *testing.T) {
+	m := newAdminService(t)
+	m.tenants[0].finished(errors.New("bank is down"))
+
+	w := serveAdmin(m, http.MethodGet, "/status")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var status serviceStatus
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "Running", status.State)
+	assert.False(t, status.Paused)
+	require.Equal(t, 1, len(status.Tenants))
+	s := status.Tenants[0]
+	assert.Equal(t, "house", s.Name)
+	assert.False(t, s.Running)
+	assert.NotNil(t, s.LastRun)
+	assert.Equal(t, "error", s.LastResult)
+	assert.Equal(t, "bank is down", s.LastError)
+	assert.Nil(t, s.LastSuccess)
+	assert.Nil(t, s.TokenValid)
+
+	require.NoError(t, m.pause(false))
+	w = serveAdmin(m, http.MethodGet, "/status")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &status))
+	assert.Equal(t, "Paused", status.State)
+	assert.True(t, status.Paused)
+}
+
+func TestAdminSync(t *testing.T) {
+	m := newAdminService(t)
+
+	w := serveAdmin(m, http.MethodPost, "/sync")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Len(t, m.tenants[0].syncs, 1)
+
+	w = serveAdmin(m, http.MethodPost, "/sync?tenant=house")
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	w = serveAdmin(m, http.MethodPost, "/sync?tenant=office")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "tenant office not found")
+
+	require.NoError(t, m.pause(false))
+	w = serveAdmin(m, http.MethodPost, "/sync")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminPauseResume(t *testing.T) {
+	m := newAdminService(t)
+
+	w := serveAdmin(m, http.MethodPost, "/pause")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, m.paused())
+	assert.FileExists(t, m.PauseFile)
+
+	w = serveAdmin(m, http.MethodPost, "/resume")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, m.paused())
+	assert.NoFileExists(t, m.PauseFile)
+
+	m.setState(StateStopPending)
+	w = serveAdmin(m, http.MethodPost, "/pause")
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestAdminMethodNotAllowed(t *testing.T) {
+	m := newAdminService(t)
+	for _, path := range []string{"/sync", "/pause", "/resume"} {
+		for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+			w := serveAdmin(m, method, path)
+			assert.Equal(t, http.StatusMethodNotAllowed, w.Code, "%s %s", method, path)
+			assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+		}
+	}
+	assert.Empty(t, m.tenants[0].syncs)
+	assert.False(t, m.paused())
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrRateLimited  = errors.New("too many requests")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrServer       = errors.New("server error")
+	ErrBadRequest   = errors.New("bad request")
+)
+
+// Error is a non 2xx response of the monobank api.
+// It unwraps to one of ErrRateLimited, ErrUnauthorized, ErrServer or ErrBadRequest.
+type Error struct {
+	StatusCode  int
+	Description string
+	// RetryAfter is set for rate limited responses, RequestInterval when the api did not tell.
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("monobank api %d: %s, retry after %s", e.StatusCode, e.Description, e.RetryAfter)
+	}
+	return fmt.Sprintf("monobank api %d: %s", e.StatusCode, e.Description)
+}
+
+func (e *Error) Unwrap() error {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case e.StatusCode >= http.StatusInternalServerError:
+		return ErrServer
+	default:
+		return ErrBadRequest
+	}
+}
+
+// Temporary reports whether repeating the request may succeed.
+func (e *Error) Temporary() bool {
+	return errors.Is(e, ErrRateLimited) || errors.Is(e, ErrServer)
+}
+
+// newError builds an Error from a response and its body, which monobank
+// fills with {"errorDescription": "..."}.
+func newError(resp *http.Response, body []byte) *Error {
+	e := &Error{StatusCode: resp.StatusCode}
+
+	var description struct {
+		ErrorDescription string `json:"errorDescription"`
+	}
+	if json.Unmarshal(body, &description) == nil && description.ErrorDescription != "" {
+		e.Description = description.ErrorDescription
+	} else {
+		e.Description = http.StatusText(resp.StatusCode)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		e.RetryAfter = RequestInterval
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			e.RetryAfter = time.Duration(s) * time.Second
+		}
+	}
+	return e
+}
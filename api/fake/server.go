@@ -0,0 +1,217 @@
+// Package fake provides an in-memory monobank personal api server for offline tests.
+package fake
+
+import (
+	"cmp"
+	"diesgen/api"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves scripted client info and statements over httptest.
+type Server struct {
+	*httptest.Server
+
+	XToken string
+
+	mu           sync.Mutex
+	client       api.Client
+	statements   map[string][]api.Transaction
+	requestCount map[string]int
+	failures     []failure
+}
+
+type failure struct {
+	prefix      string
+	status      int
+	description string
+	count       int
+}
+
+// NewServer starts a server accepting requests with the given token.
+// Callers must Close it when done.
+func NewServer(xToken string) *Server {
+	s := &Server{
+		XToken:       xToken,
+		statements:   make(map[string][]api.Transaction),
+		requestCount: make(map[string]int),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/personal/client-info", s.handleClientInfo)
+	mux.HandleFunc("/personal/statement/", s.handleStatement)
+	mux.HandleFunc("/personal/webhook", s.handleWebHook)
+	s.Server = httptest.NewServer(s.authorize(mux))
+	return s
+}
+
+// AddJar registers a jar returned from client-info.
+func (s *Server) AddJar(j api.Jar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.client.Jars = append(s.client.Jars, j)
+}
+
+// AddTransactions appends transactions to the statement of the account or jar.
+func (s *Server) AddTransactions(accountId string, transactions ...api.Transaction) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statements[accountId] = append(s.statements[accountId], transactions...)
+}
+
+// Fail makes the next count requests with the path prefix answer with status
+// and the monobank error body.
+func (s *Server) Fail(prefix string, status int, description string, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, failure{prefix: prefix, status: status, description: description, count: count})
+}
+
+// Requests returns how many times the path prefix was requested.
+func (s *Server) Requests(prefix string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	for path, count := range s.requestCount {
+		if strings.HasPrefix(path, prefix) {
+			n += count
+		}
+	}
+	return n
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requestCount[r.URL.Path]++
+		f, failed := s.nextFailure(r.URL.Path)
+		s.mu.Unlock()
+
+		if failed {
+			writeError(w, f.status, f.description)
+			return
+		}
+
+		if r.Header.Get("X-Token") != s.XToken {
+			writeError(w, http.StatusForbidden, "Unknown 'X-Token'")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) nextFailure(path string) (failure, bool) {
+	for i := range s.failures {
+		f := &s.failures[i]
+		if f.count > 0 && strings.HasPrefix(path, f.prefix) {
+			f.count--
+			return *f, true
+		}
+	}
+	return failure{}, false
+}
+
+func (s *Server) handleClientInfo(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.client)
+}
+
+// WebHookURL returns the registered webhook url.
+func (s *Server) WebHookURL() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.client.WebHookUrl
+}
+
+// handleWebHook registers the webhook url after checking it answers a GET request like the real api.
+func (s *Server) handleWebHook(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		WebHookUrl string `json:"webHookUrl"`
+	}
+	if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+		writeError(w, http.StatusBadRequest, "invalid webhook request")
+		return
+	}
+
+	resp, err := http.Get(body.WebHookUrl)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		writeError(w, http.StatusBadRequest, "webhook check failed: "+resp.Status)
+		return
+	}
+
+	s.mu.Lock()
+	s.client.WebHookUrl = body.WebHookUrl
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// handleStatement serves /personal/statement/{account}/{from}/{to} newest first like the real api,
+// rejecting periods longer than api.MaxStatementPeriod and truncating to api.MaxStatementItems.
+func (s *Server) handleStatement(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/personal/statement/"), "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		writeError(w, http.StatusBadRequest, "invalid statement path")
+		return
+	}
+
+	from, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid from")
+		return
+	}
+	to := time.Now().Unix()
+	if len(parts) == 3 {
+		to, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid to")
+			return
+		}
+	}
+
+	if time.Duration(to-from)*time.Second > api.MaxStatementPeriod {
+		writeError(w, http.StatusBadRequest, "Period must be no more than 31 days")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	transactions := make([]api.Transaction, 0)
+	for _, t := range s.statements[parts[0]] {
+		if t.Time >= from && t.Time <= to {
+			transactions = append(transactions, t)
+		}
+	}
+	slices.SortStableFunc(transactions, func(a, b api.Transaction) int {
+		return cmp.Compare(b.Time, a.Time)
+	})
+	if len(transactions) > api.MaxStatementItems {
+		transactions = transactions[:api.MaxStatementItems]
+	}
+
+	writeJSON(w, http.StatusOK, transactions)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, description string) {
+	writeJSON(w, status, map[string]string{"errorDescription": description})
+}
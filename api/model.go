@@ -1,5 +1,7 @@
 package api
 
+import "diesgen/money"
+
 type Account struct {
 	ID           string   `json:"id"`
 	SendID       string   `json:"sendId"`
@@ -51,3 +53,20 @@ type Transaction struct {
 	CounterIban     string `json:"counterIban"`
 	CounterName     string `json:"counterName"`
 }
+
+// Money returns the amount of the transaction in its currency.
+func (t Transaction) Money() money.Money {
+	return money.New(int64(t.Amount), t.CurrencyCode)
+}
+
+// StatementItemEvent is the WebHookEvent type of a new transaction.
+const StatementItemEvent = "StatementItem"
+
+// WebHookEvent is pushed by monobank to the registered webhook url.
+type WebHookEvent struct {
+	Type string `json:"type"`
+	Data struct {
+		Account       string      `json:"account"`
+		StatementItem Transaction `json:"statementItem"`
+	} `json:"data"`
+}
@@ -1,93 +1,183 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"diesgen/logging"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
-func GetClient(xToken string) (*Client, error) {
-	url := "https://api.monobank.ua/personal/client-info"
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("Error creating request: %w\n", err)
+const DefaultBaseURL = "https://api.monobank.ua"
+
+// MonobankClient is the subset of the monobank personal api used by diesgen.
+type MonobankClient interface {
+	// ClientInfo returns the client with its accounts and jars.
+	ClientInfo(ctx context.Context) (*Client, error)
+	// Statement returns the transactions of the account or jar in the given time range.
+	Statement(ctx context.Context, accountId string, from time.Time, to time.Time) ([]Transaction, error)
+	// SetWebHook registers the url monobank pushes WebHookEvent to.
+	SetWebHook(ctx context.Context, url string) error
+}
+
+// RetryPolicy controls how transient failures are retried.
+// The delay starts at Backoff and doubles after every attempt up to MaxBackoff.
+// Rate limited responses are retried only when the api asks to wait no longer than MaxBackoff.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{Attempts: 3, Backoff: time.Second, MaxBackoff: 10 * time.Second}
+
+// Monobank is a MonobankClient talking to the monobank personal api over http.
+type Monobank struct {
+	baseURL    string
+	xToken     string
+	httpClient *http.Client
+	retry      RetryPolicy
+}
+
+// NewMonobank returns a client for the api at baseURL, DefaultBaseURL is used when it is empty.
+// A nil httpClient is replaced with http.DefaultClient.
+func NewMonobank(baseURL string, xToken string, httpClient *http.Client) *Monobank {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Token", xToken)
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Monobank{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		xToken:     xToken,
+		httpClient: httpClient,
+		retry:      DefaultRetryPolicy,
+	}
+}
+
+// SetRetryPolicy replaces DefaultRetryPolicy for the following requests.
+func (m *Monobank) SetRetryPolicy(p RetryPolicy) {
+	m.retry = p
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (m *Monobank) ClientInfo(ctx context.Context) (*Client, error) {
+	var apiClient Client
+	err := m.get(ctx, "/personal/client-info", &apiClient)
 	if err != nil {
-		return nil, fmt.Errorf("Error making GET request: %w\n", err)
+		return nil, err
 	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
+	return &apiClient, nil
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (m *Monobank) Statement(ctx context.Context, accountId string, from time.Time, to time.Time) ([]Transaction, error) {
+	path := fmt.Sprintf("/personal/statement/%s/%d/%d", accountId, from.Unix(), to.Unix())
+
+	var transactions []Transaction
+	err := m.get(ctx, path, &transactions)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading response body: %w\n", err)
+		return nil, err
 	}
+	return transactions, nil
+}
 
-	var apiClient Client
-	err = json.Unmarshal(body, &apiClient)
+// SetWebHook is not retried, monobank checks the url with a GET request before it answers.
+func (m *Monobank) SetWebHook(ctx context.Context, url string) error {
+	body, err := json.Marshal(struct {
+		WebHookUrl string `json:"webHookUrl"`
+	}{url})
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing response body: %w\n", err)
+		return err
 	}
-
-	return &apiClient, nil
+	return m.do(ctx, "POST", "/personal/webhook", body, nil)
 }
 
-func GetJar(name string, jars []Jar) *Jar {
-	for _, jar := range jars {
-		if jar.Title == name {
-			return &jar
+// get retries temporary failures until ctx is done.
+func (m *Monobank) get(ctx context.Context, path string, v any) error {
+	backoff := m.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err := m.do(ctx, "GET", path, nil, v)
+		if err == nil || attempt >= m.retry.Attempts {
+			return err
+		}
+
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			if !apiErr.Temporary() {
+				return err
+			}
+			if apiErr.RetryAfter > m.retry.MaxBackoff {
+				return err
+			}
+			backoff = max(backoff, apiErr.RetryAfter)
+		}
+
+		logging.Entry(ctx).Warnf("GET %s failed, retrying in %s: %v", path, backoff, err)
+		if err := sleep(ctx, backoff); err != nil {
+			return err
 		}
+		backoff = min(2*backoff, m.retry.MaxBackoff)
 	}
-	return nil
 }
 
-func GetStatement(xToken string, accountId string, from time.Time, to time.Time) ([]Transaction, error) {
-	urlFormat := "https://api.monobank.ua/personal/statement/%s/%d/%d"
-	url := fmt.Sprintf(urlFormat, accountId, from.Unix(), to.Unix())
-
-	req, err := http.NewRequest("GET", url, nil)
+// do sends the request with the json body and decodes the response into v unless it is nil.
+// The request is aborted when ctx is done.
+func (m *Monobank) do(ctx context.Context, method string, path string, body []byte, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, m.baseURL+path, bytes.NewReader(body))
 	if err != nil {
-		return nil, fmt.Errorf("Error creating request: %w\n", err)
+		return fmt.Errorf("Error creating request: %w\n", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Token", xToken)
+	req.Header.Set("X-Token", m.xToken)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := m.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Error making GET request: %w\n", err)
+		return fmt.Errorf("Error making %s request: %w\n", method, err)
 	}
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
 
-	body, err := io.ReadAll(resp.Body)
+	respBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading response body: %w\n", err)
+		return fmt.Errorf("Error reading response body: %w\n", err)
 	}
 
-	var transactions []Transaction
-	err = json.Unmarshal(body, &transactions)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newError(resp, respBody)
+	}
+	if v == nil {
+		return nil
+	}
+
+	err = json.Unmarshal(respBody, v)
 	if err != nil {
-		return nil, fmt.Errorf("Error parsing response body: %w\n", err)
+		return fmt.Errorf("Error parsing response body: %w\n", err)
 	}
 
-	return transactions, nil
+	return nil
 }
 
-func GetStatementFromToNow(xToken string, j Jar, from string) ([]Transaction, error) {
-	layout := "2006-01-02 15:04:05 -0700 MST"
-	t, err := time.Parse(layout, from)
-	if err != nil {
-		return nil, err
+// GetJarByID returns the jar with the id, nil when there is none.
+func GetJarByID(id string, jars []Jar) *Jar {
+	for _, jar := range jars {
+		if jar.ID == id {
+			return &jar
+		}
 	}
-	return GetStatement(xToken, j.ID, t, time.Now())
+	return nil
+}
+
+func GetJar(name string, jars []Jar) *Jar {
+	for _, jar := range jars {
+		if jar.Title == name {
+			return &jar
+		}
+	}
+	return nil
 }
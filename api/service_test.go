@@ -0,0 +1,113 @@
+package api_test
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/api/fake"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = api.RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+func TestClientInfoErrors(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+
+	mono := api.NewMonobank(server.URL, "invalid", nil)
+	mono.SetRetryPolicy(testRetryPolicy)
+
+	_, err := mono.ClientInfo(context.Background())
+	require.ErrorIs(t, err, api.ErrUnauthorized)
+
+	var apiErr *api.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Equal(t, "Unknown 'X-Token'", apiErr.Description)
+	// not retried
+	assert.Equal(t, 1, server.Requests("/personal/client-info"))
+}
+
+func TestClientInfoRateLimited(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+	server.Fail("/personal/client-info", http.StatusTooManyRequests, "Too many requests", 1)
+
+	mono := api.NewMonobank(server.URL, "token", nil)
+	mono.SetRetryPolicy(testRetryPolicy)
+
+	_, err := mono.ClientInfo(context.Background())
+	require.ErrorIs(t, err, api.ErrRateLimited)
+
+	var apiErr *api.Error
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, api.RequestInterval, apiErr.RetryAfter)
+	assert.Equal(t, 1, server.Requests("/personal/client-info"))
+}
+
+func TestClientInfoRetriesServerErrors(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+	server.AddJar(api.Jar{ID: "jar", Title: "Diesel"})
+	server.Fail("/personal/client-info", http.StatusBadGateway, "Bad gateway", 2)
+
+	mono := api.NewMonobank(server.URL, "token", nil)
+	mono.SetRetryPolicy(testRetryPolicy)
+
+	client, err := mono.ClientInfo(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, len(client.Jars))
+	assert.Equal(t, 3, server.Requests("/personal/client-info"))
+
+	server.Fail("/personal/client-info", http.StatusInternalServerError, "", 3)
+	_, err = mono.ClientInfo(context.Background())
+	assert.ErrorIs(t, err, api.ErrServer)
+}
+
+func TestSetWebHook(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/webhook" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer receiver.Close()
+
+	mono := api.NewMonobank(server.URL, "token", nil)
+	mono.SetRetryPolicy(testRetryPolicy)
+
+	// the url is checked before it is registered
+	err := mono.SetWebHook(context.Background(), receiver.URL+"/missing")
+	require.ErrorIs(t, err, api.ErrBadRequest)
+	assert.Empty(t, server.WebHookURL())
+
+	err = mono.SetWebHook(context.Background(), receiver.URL+"/webhook")
+	require.NoError(t, err)
+	client, err := mono.ClientInfo(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, receiver.URL+"/webhook", client.WebHookUrl)
+}
+
+func TestClientInfoCanceled(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := api.NewMonobank(server.URL, "token", nil).ClientInfo(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"diesgen/logging"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxStatementPeriod is the longest time range accepted by a single statement request.
+	MaxStatementPeriod = 31*24*time.Hour + time.Hour
+	// MaxStatementItems is the number of transactions after which a statement is truncated.
+	MaxStatementItems = 500
+	// RequestInterval is the minimal time between two requests for the same account.
+	RequestInterval = 60 * time.Second
+)
+
+// RateLimiter lets one request per interval through for every key.
+type RateLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func NewRateLimiter(interval time.Duration) *RateLimiter {
+	return &RateLimiter{interval: interval, next: make(map[string]time.Time)}
+}
+
+// Wait blocks until a request for key is allowed and reserves the slot. It returns the error
+// of ctx when ctx is done first, the slot stays reserved then.
+func (l *RateLimiter) Wait(ctx context.Context, key string) error {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next[key]
+	if at.Before(now) {
+		at = now
+	}
+	l.next[key] = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if d := at.Sub(now); d > 0 {
+		logging.Entry(ctx).Infof("rate limit: waiting %s for %s", d.Round(time.Second), key)
+		return sleep(ctx, d)
+	}
+	return ctx.Err()
+}
+
+// sleep pauses for d or until ctx is done, returning the error of ctx then.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Delay postpones the next request for key by at least d, e.g. after the api reported a rate limit.
+func (l *RateLimiter) Delay(key string, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if at := time.Now().Add(d); at.After(l.next[key]) {
+		l.next[key] = at
+	}
+}
+
+// StatementFetcher reads statements of any length by splitting the time range into
+// MaxStatementPeriod windows and following truncated responses.
+type StatementFetcher struct {
+	Client  MonobankClient
+	Limiter *RateLimiter
+	// Key is the limiter key of the requests, the account id when empty
+	Key string
+}
+
+// Fetch returns the transactions of the account in the time range, newest first.
+func (f *StatementFetcher) Fetch(ctx context.Context, accountId string, from time.Time, to time.Time) ([]Transaction, error) {
+	var transactions []Transaction
+	err := f.FetchWindows(ctx, accountId, from, to, func(_ time.Time, _ time.Time, items []Transaction) error {
+		// windows come oldest first, the api orders transactions newest first
+		transactions = append(items, transactions...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// FetchWindows reads the time range window by window from the oldest and calls fn with the range
+// of every window and its transactions, so the progress of a long range survives an error.
+func (f *StatementFetcher) FetchWindows(ctx context.Context, accountId string, from time.Time, to time.Time,
+	fn func(windowFrom time.Time, windowTo time.Time, transactions []Transaction) error) error {
+	seen := make(map[string]bool)
+
+	for windowFrom := from; windowFrom.Before(to); {
+		windowTo := windowFrom.Add(MaxStatementPeriod - time.Second)
+		if windowTo.After(to) {
+			windowTo = to
+		}
+
+		items, err := f.fetchWindow(ctx, accountId, windowFrom, windowTo)
+		if err != nil {
+			return err
+		}
+		var transactions []Transaction
+		for _, t := range items {
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+			transactions = append(transactions, t)
+		}
+		err = fn(windowFrom, windowTo, transactions)
+		if err != nil {
+			return err
+		}
+
+		windowFrom = windowTo.Add(time.Second)
+	}
+	return nil
+}
+
+// fetchWindow reads a range shorter than MaxStatementPeriod re-querying from the
+// oldest returned transaction while the api truncates the response.
+func (f *StatementFetcher) fetchWindow(ctx context.Context, accountId string, from time.Time, to time.Time) ([]Transaction, error) {
+	var transactions []Transaction
+	for {
+		items, err := f.statement(ctx, accountId, from, to)
+		if err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, items...)
+
+		if len(items) < MaxStatementItems {
+			return transactions, nil
+		}
+
+		oldest := items[0].Time
+		for _, t := range items {
+			oldest = min(oldest, t.Time)
+		}
+
+		next := time.Unix(oldest, 0)
+		if !next.Before(to) {
+			// a whole page within one second, step over it to make progress
+			logging.Entry(ctx).Warnf("statement %s has more than %d transactions at %s", accountId, MaxStatementItems, next)
+			next = next.Add(-time.Second)
+		}
+		if next.Before(from) {
+			return transactions, nil
+		}
+		to = next
+	}
+}
+
+// statement requests a single page through the limiter, waiting out rate limited responses.
+func (f *StatementFetcher) statement(ctx context.Context, accountId string, from time.Time, to time.Time) ([]Transaction, error) {
+	const rateLimitedAttempts = 3
+
+	key := f.Key
+	if key == "" {
+		key = accountId
+	}
+
+	for attempt := 1; ; attempt++ {
+		if f.Limiter != nil {
+			if err := f.Limiter.Wait(ctx, key); err != nil {
+				return nil, err
+			}
+		}
+
+		items, err := f.Client.Statement(ctx, accountId, from, to)
+		var apiErr *Error
+		if f.Limiter == nil || attempt >= rateLimitedAttempts ||
+			!errors.As(err, &apiErr) || !errors.Is(apiErr, ErrRateLimited) {
+			return items, err
+		}
+
+		logging.Entry(ctx).Warnf("statement %s rate limited: %v", accountId, err)
+		f.Limiter.Delay(key, apiErr.RetryAfter)
+	}
+}
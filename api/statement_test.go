@@ -0,0 +1,113 @@
+package api_test
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/api/fake"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestStatementFetcherPaginates(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+
+	to := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-70 * 24 * time.Hour)
+
+	// 1300 transactions in 70 days with 700 of them in a single day
+	const total = 1300
+	for i := 0; i < 600; i++ {
+		at := from.Add(time.Duration(i) * 2 * time.Hour)
+		server.AddTransactions("jar", api.Transaction{ID: strconv.Itoa(i), Time: at.Unix(), Amount: 100})
+	}
+	busyDay := from.Add(60 * 24 * time.Hour)
+	for i := 600; i < total; i++ {
+		at := busyDay.Add(time.Duration(i) * time.Minute)
+		server.AddTransactions("jar", api.Transaction{ID: strconv.Itoa(i), Time: at.Unix(), Amount: 100})
+	}
+
+	fetcher := &api.StatementFetcher{Client: api.NewMonobank(server.URL, "token", nil)}
+	transactions, err := fetcher.Fetch(context.Background(), "jar", from, to)
+	require.NoError(t, err)
+
+	ids := make(map[string]bool)
+	for _, tr := range transactions {
+		ids[tr.ID] = true
+	}
+	assert.Equal(t, total, len(transactions))
+	assert.Equal(t, total, len(ids))
+	assert.Greater(t, server.Requests("/personal/statement/"), 3)
+}
+
+func TestStatementFetcherWindows(t *testing.T) {
+	server := fake.NewServer("token")
+	defer server.Close()
+
+	to := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+	from := to.Add(-70 * 24 * time.Hour)
+	for i := 0; i < 70; i++ {
+		at := from.Add(time.Duration(i)*24*time.Hour + time.Hour)
+		server.AddTransactions("jar", api.Transaction{ID: strconv.Itoa(i), Time: at.Unix(), Amount: 100})
+	}
+
+	fetcher := &api.StatementFetcher{Client: api.NewMonobank(server.URL, "token", nil)}
+	var ends []time.Time
+	var total int
+	stop := errors.New("stop")
+	err := fetcher.FetchWindows(context.Background(), "jar", from, to, func(_ time.Time, windowTo time.Time, items []api.Transaction) error {
+		// the windows come oldest first
+		for _, tr := range items {
+			assert.False(t, time.Unix(tr.Time, 0).After(windowTo))
+			if len(ends) > 0 {
+				assert.True(t, time.Unix(tr.Time, 0).After(ends[len(ends)-1]))
+			}
+		}
+		ends = append(ends, windowTo)
+		total += len(items)
+		if len(ends) == 2 {
+			return stop
+		}
+		return nil
+	})
+	require.ErrorIs(t, err, stop)
+	require.Equal(t, 2, len(ends))
+	assert.Equal(t, from.Add(api.MaxStatementPeriod-time.Second), ends[0])
+	assert.Equal(t, 63, total)
+
+	transactions, err := fetcher.Fetch(context.Background(), "jar", from, to)
+	require.NoError(t, err)
+	require.Equal(t, 70, len(transactions))
+	assert.Equal(t, "69", transactions[0].ID)
+	assert.Equal(t, "0", transactions[69].ID)
+}
+
+func TestRateLimiter(t *testing.T) {
+	const interval = 50 * time.Millisecond
+	limiter := api.NewRateLimiter(interval)
+
+	start := time.Now()
+	require.NoError(t, limiter.Wait(context.Background(), "a"))
+	require.NoError(t, limiter.Wait(context.Background(), "b"))
+	assert.Less(t, time.Since(start), interval)
+
+	require.NoError(t, limiter.Wait(context.Background(), "a"))
+	require.NoError(t, limiter.Wait(context.Background(), "a"))
+	assert.GreaterOrEqual(t, time.Since(start), 2*interval)
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	limiter := api.NewRateLimiter(time.Hour)
+	require.NoError(t, limiter.Wait(context.Background(), "a"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := limiter.Wait(ctx, "a")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/schedule"
+	"diesgen/service"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+func runSync(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("sync")
+	dryRun := fs.Bool("dry-run", false, "print what the sync would change without writing the store, xlsx and config")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+
+	// an interrupt abandons the requests in flight
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if !*dryRun {
+		return service.Process(ctx, g.options())
+	}
+
+	diffs, err := service.DryRun(ctx, g.options())
+	for i, d := range diffs {
+		if i > 0 {
+			_, _ = fmt.Fprintln(out)
+		}
+		if err := printDiff(out, d); err != nil {
+			return err
+		}
+	}
+	// campaigns that could not be fetched are reported after the others
+	return err
+}
+
+func printDiff(out io.Writer, d *service.Diff) error {
+	if d.IsEmpty() {
+		_, err := fmt.Fprintf(out, "Campaign %s is up to date\n", d.Campaign)
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "Campaign %s\n", d.Campaign)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if len(d.Changes) > 0 {
+		_, _ = fmt.Fprintln(tw, "\nTRANSACTION\tTIME\tAMOUNT\tCOMMENT\tFLAT\tSOURCE")
+		for _, c := range d.Changes {
+			t := c.After.Transaction
+			flat := strconv.Itoa(c.After.Flat)
+			source := string(c.After.Source)
+			if c.Before == nil {
+				flat = "+ " + flat
+			} else {
+				flat = fmt.Sprintf("%d -> %d", c.Before.Flat, c.After.Flat)
+				source = fmt.Sprintf("%s -> %s", c.Before.Source, c.After.Source)
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%q\t%s\t%s\n", t.ID,
+				time.Unix(t.Time, 0).Format(time.DateTime), t.Money(), t.Comment, flat, source)
+		}
+	}
+
+	if len(d.Flats) > 0 {
+		_, _ = fmt.Fprintln(tw, "\nFLAT\tBEFORE\tAFTER\tCHANGE")
+		for _, f := range d.Flats {
+			before := f.Before.String()
+			if f.New {
+				before = "new row"
+			}
+			change, err := f.After.Sub(f.Before)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", f.Flat, before, f.After, change)
+		}
+	}
+
+	if len(d.Exclusions) > 0 {
+		_, _ = fmt.Fprintln(tw, "\nNEW EXCLUSION\tAMOUNT\tCOMMENT")
+		for _, e := range d.Exclusions {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%q\n", e.TransactionID, e.Amount, e.Comment)
+		}
+	}
+	return tw.Flush()
+}
+
+func runValidateConfig(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("validate-config")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+
+	c, err := config.GetConfig(g.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if err := validateConfig(c); err != nil {
+		return fmt.Errorf("invalid config %s:\n%w", g.ConfigPath, err)
+	}
+
+	_, err = fmt.Fprintf(out, "config %s is valid\n", g.ConfigPath)
+	return err
+}
+
+// validateConfig checks the config and compiles the schedule and the comment rules and expense
+// categories of every campaign.
+func validateConfig(c *config.Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	if _, err := schedule.FromConfig(c); err != nil {
+		return err
+	}
+	for _, cp := range c.CampaignList() {
+		if _, err := rules.FromConfig(c.For(cp)); err != nil {
+			return fmt.Errorf("campaign %s: %w", cp.ID(), err)
+		}
+		if _, err := rules.ExpensesFromConfig(c.For(cp)); err != nil {
+			return fmt.Errorf("campaign %s: %w", cp.ID(), err)
+		}
+	}
+	return nil
+}
+
+func runRebuildXlsx(args []string, _ io.Reader, _ io.Writer) error {
+	fs, g := newFlagSet("rebuild-xlsx")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+
+	err := service.RebuildWorkbook(context.Background(), g.options())
+	if err != nil {
+		return err
+	}
+	log.Infof("%s rebuilt", g.XlsxPath)
+	return nil
+}
+
+func runShowJar(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("show-jar")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+
+	c, err := config.GetConfig(g.ConfigPath)
+	if err != nil {
+		return err
+	}
+	client, err := service.NewClient(c, g.Timeouts.Request).ClientInfo(context.Background())
+	if err != nil {
+		return fmt.Errorf("client info: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "\tTITLE\tID\tBALANCE\tGOAL")
+	campaigns := c.CampaignList()
+	for _, j := range client.Jars {
+		selected := ""
+		if slices.ContainsFunc(campaigns, func(cp config.Campaign) bool {
+			return cp.JarID == j.ID || (cp.JarID == "" && cp.JarName == j.Title)
+		}) {
+			selected = "*"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%q\t%s\t%s\t%s\n", selected, j.Title, j.ID,
+			money.New(int64(j.Balance), j.CurrencyCode), money.New(int64(j.Goal), j.CurrencyCode))
+	}
+	return tw.Flush()
+}
+
+func runReport(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("report")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+
+	reports, err := service.BuildReport(g.options())
+	if err != nil {
+		return err
+	}
+
+	for i, r := range reports {
+		if i > 0 {
+			_, _ = fmt.Fprintln(out)
+		}
+		if err := printReport(out, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printReport(out io.Writer, r *service.Report) error {
+	last := "-"
+	if !r.Last.IsZero() {
+		last = r.Last.Format(time.DateTime)
+	}
+	_, _ = fmt.Fprintf(out, "Campaign: %s\nTransactions: %d\nLast transaction: %s\nIncome: %s\nWithdrawn: %s\n\n",
+		r.Campaign, r.Transactions, last, r.Income, r.Withdrawn)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "FLAT\tTRANSACTIONS\tPAID")
+	for _, f := range r.Flats {
+		flat := strconv.Itoa(f.Flat)
+		if f.Flat == 0 {
+			flat = "unknown"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\n", flat, f.Transactions, f.Paid)
+	}
+
+	if len(r.Expenses) > 0 {
+		_, _ = fmt.Fprintln(tw, "\nCATEGORY\tTRANSACTIONS\tSPENT")
+		for _, e := range r.Expenses {
+			_, _ = fmt.Fprintf(tw, "%s\t%d\t%s\n", e.Category, e.Transactions, e.Spent)
+		}
+	}
+	return tw.Flush()
+}
+
+func runTestRules(args []string, in io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("test-rules")
+	if err := g.parse(fs, args, nil); err != nil {
+		return err
+	}
+	return testRules(g.ConfigPath, fs.Args(), in, out)
+}
+
+// testRules prints which comment rule of every campaign matches every comment, comments are
+// read line by line from in when none are given.
+func testRules(configPath string, comments []string, in io.Reader, w io.Writer) error {
+	c, err := config.GetConfig(configPath)
+	if err != nil {
+		return err
+	}
+	campaigns := c.CampaignList()
+	engines := make([]*rules.Engine, 0, len(campaigns))
+	for _, cp := range campaigns {
+		engine, err := rules.FromConfig(c.For(cp))
+		if err != nil {
+			return fmt.Errorf("campaign %s: %w", cp.ID(), err)
+		}
+		engines = append(engines, engine)
+	}
+
+	if len(comments) == 0 {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			comments = append(comments, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	for i, engine := range engines {
+		if len(campaigns) > 1 {
+			if i > 0 {
+				_, _ = fmt.Fprintln(w)
+			}
+			_, _ = fmt.Fprintf(w, "Campaign %s\n", campaigns[i].ID())
+		}
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "COMMENT\tRULE\tFLAT\tCARD\tERROR")
+		for _, comment := range comments {
+			m, err := engine.Parse(comment)
+			if err != nil {
+				_, _ = fmt.Fprintf(tw, "%q\t-\t-\t-\t%v\n", comment, err)
+				continue
+			}
+			_, _ = fmt.Fprintf(tw, "%q\t%s\t%d\t%s\n", comment, m.Rule, m.Flat, m.Card)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"diesgen/api"
+	"diesgen/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configFlags writes the config to a temp dir and returns the flags of a command using it.
+func configFlags(t *testing.T, c config.Config) []string {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, config.SetConfig(path, c))
+	return []string{"-config", path, "-log", filepath.Join(dir, "diesgen.log")}
+}
+
+func TestShowJar(t *testing.T) {
+	server, o := newTestTenant(t)
+	server.AddJar(api.Jar{ID: "jar2", Title: "Starlink", CurrencyCode: 980, Balance: 125_050, Goal: 2_000_000})
+
+	var out bytes.Buffer
+	err := runShowJar([]string{"-config", o.ConfigPath, "-log", filepath.Join(t.TempDir(), "diesgen.log")}, nil, &out)
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "TITLE")
+	// the jar of the config is marked
+	assert.True(t, strings.HasPrefix(lines[1], "*"), lines[1])
+	assert.Contains(t, lines[1], `"Diesel"`)
+	assert.False(t, strings.HasPrefix(lines[2], "*"), lines[2])
+	assert.Contains(t, lines[2], `"Starlink"`)
+	assert.Contains(t, lines[2], "jar2")
+	assert.Contains(t, lines[2], "1250.5")
+	assert.Contains(t, lines[2], "20000")
+}
+
+func TestValidateConfig(t *testing.T) {
+	c := config.Config{XToken: "token", Campaigns: []config.Campaign{
+		{JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST"},
+		{JarName: "Repair", JarStart: "2024-09-01 00:00:00 +0300 EEST", Sheet: "Repair"},
+	}}
+	flags := configFlags(t, c)
+
+	var out bytes.Buffer
+	require.NoError(t, runValidateConfig(flags, nil, &out))
+	assert.Contains(t, out.String(), "is valid")
+
+	// the rules of every campaign are compiled
+	c.Campaigns[1].Parsing = &config.Parsing{Rules: []config.Rule{{Name: "broken", Pattern: "(?P<flat>"}}}
+	err := runValidateConfig(configFlags(t, c), nil, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "campaign Repair: rule broken")
+
+	c.Campaigns[1].Parsing = nil
+	c.Campaigns[0].JarStart = ""
+	err = runValidateConfig(configFlags(t, c), nil, &bytes.Buffer{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid config")
+}
+
+func TestTestRules(t *testing.T) {
+	flags := configFlags(t, config.Config{XToken: "token", JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST"})
+
+	var out bytes.Buffer
+	require.NoError(t, runTestRules(flags, strings.NewReader("кв 12\nno flat here\n"), &out))
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "COMMENT")
+	assert.Regexp(t, `^"кв 12"\s+keyword\s+12\s`, lines[1])
+	assert.Regexp(t, `^"no flat here"\s+-`, lines[2])
+
+	// every campaign matches with its own rules
+	flags = configFlags(t, config.Config{XToken: "token", Campaigns: []config.Campaign{
+		{JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST"},
+		{JarName: "Repair", JarStart: "2024-09-01 00:00:00 +0300 EEST", Sheet: "Repair", Parsing: &config.Parsing{
+			Rules: []config.Rule{{Name: "office", Pattern: `office (?P<flat>\d+)`}},
+		}},
+	}})
+	out.Reset()
+	require.NoError(t, runTestRules(append(flags, "office 7"), nil, &out))
+	sections := strings.Split(strings.TrimSpace(out.String()), "\n\n")
+	require.Len(t, sections, 2)
+	assert.True(t, strings.HasPrefix(sections[0], "Campaign Diesel\n"), sections[0])
+	assert.Regexp(t, `"office 7"\s+digits\s+7\b`, sections[0])
+	assert.True(t, strings.HasPrefix(sections[1], "Campaign Repair\n"), sections[1])
+	assert.Regexp(t, `"office 7"\s+office\s+7\b`, sections[1])
+}
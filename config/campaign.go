@@ -0,0 +1,67 @@
+package config
+
+import (
+	"slices"
+)
+
+// Campaign is a collection into a jar processed on its own: its transactions are attributed
+// with its parsing rules and written to its sheet and workbook.
+type Campaign struct {
+	// Name identifies the campaign in the store and logs, the jar name or id when empty
+	Name     string `json:"name,omitempty"`
+	JarName  string `json:"jarName,omitempty"`
+	JarID    string `json:"jarId,omitempty"`
+	JarStart string `json:"jarStart"`
+	JarEnd   string `json:"jarEnd,omitempty"`
+	Sheet    string `json:"sheet,omitempty"`
+	// Xlsx is the workbook path relative to the config, the service workbook when empty
+	Xlsx string `json:"xlsx,omitempty"`
+	// Parsing and Expenses replace the top level ones when set
+	Parsing  *Parsing          `json:"parsing,omitempty"`
+	Expenses []ExpenseCategory `json:"expenses,omitempty"`
+}
+
+// ID returns the name identifying the campaign.
+func (cp Campaign) ID() string {
+	switch {
+	case cp.Name != "":
+		return cp.Name
+	case cp.JarName != "":
+		return cp.JarName
+	default:
+		return cp.JarID
+	}
+}
+
+// CampaignList returns the configured campaigns, a config without campaigns is the only one itself.
+func (c *Config) CampaignList() []Campaign {
+	if len(c.Campaigns) > 0 {
+		return c.Campaigns
+	}
+	return []Campaign{{
+		JarName:  c.JarName,
+		JarID:    c.JarID,
+		JarStart: c.JarStart,
+		JarEnd:   c.JarEnd,
+		Sheet:    c.Sheet,
+	}}
+}
+
+// For returns the config the campaign is processed with: the jar, dates, sheet and rules of the
+// campaign with the shared token, flat registry and exclusions.
+func (c *Config) For(cp Campaign) *Config {
+	v := *c
+	v.Campaigns = nil
+	v.JarName = cp.JarName
+	v.JarID = cp.JarID
+	v.JarStart = cp.JarStart
+	v.JarEnd = cp.JarEnd
+	v.Sheet = cp.Sheet
+	if cp.Parsing != nil {
+		v.Parsing = *cp.Parsing
+	}
+	if len(cp.Expenses) > 0 {
+		v.Expenses = slices.Clone(cp.Expenses)
+	}
+	return &v
+}
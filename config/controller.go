@@ -1,32 +1,196 @@
 package config
 
 import (
+	"diesgen/money"
+	"diesgen/safefile"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type Exclusion struct {
-	Card          string `json:"card"`
-	Flat          int    `json:"flat"`
-	Comment       string `json:"comment"`
-	TransactionID string `json:"transactionID"`
-	Amount        int    `json:"amount"`
+	Card          string      `json:"card"`
+	Flat          int         `json:"flat"`
+	Comment       string      `json:"comment"`
+	TransactionID string      `json:"transactionID"`
+	Amount        money.Money `json:"amount"`
 }
 
+// Rule is a named regular expression matching payment comments. The named groups
+// flat and card are extracted, flat is required, other groups are ignored.
+type Rule struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+}
+
+// Parsing configures how flats are found in payment comments. Keywords such as "кв" mark the
+// flat number and are tried first, then Rules in order. Flats outside FlatMin..FlatMax are rejected,
+// a zero FlatMax allows any flat of up to four digits.
+type Parsing struct {
+	Keywords []string `json:"keywords,omitempty"`
+	Rules    []Rule   `json:"rules,omitempty"`
+	FlatMin  int      `json:"flatMin,omitempty"`
+	FlatMax  int      `json:"flatMax,omitempty"`
+}
+
+// ExpenseCategory names withdrawals by their merchant category code or by a regular
+// expression matching their description or comment.
+type ExpenseCategory struct {
+	Name    string `json:"name"`
+	MCCs    []int  `json:"mccs,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// Schedule configures when the service syncs. Durations are written like "5m", quiet hours
+// like "23:00-07:00" in the local time of the service.
+type Schedule struct {
+	// Interval is the time between syncs, a minute when neither it nor Cron is set
+	Interval string `json:"interval,omitempty"`
+	// Cron is a five field cron expression of the sync times replacing Interval
+	Cron string `json:"cron,omitempty"`
+	// QuietHours are the time ranges in which no sync starts
+	QuietHours []string `json:"quietHours,omitempty"`
+	// Jitter is the longest random delay added to every sync so instances sharing a token do not collide
+	Jitter string `json:"jitter,omitempty"`
+}
+
+// Config is the config of the instance. Its jar fields describe the only campaign when Campaigns
+// is empty, the shared parsing rules and expense categories otherwise.
 type Config struct {
-	XToken     string      `json:"xToken"`
-	JarName    string      `json:"jarName"`
-	JarStart   string      `json:"jarStart"`
+	XToken     string `json:"xToken"`
+	APIBaseURL string `json:"apiBaseUrl,omitempty"`
+	// WebhookURL is the public url monobank pushes the transactions of the token to when the
+	// service runs the webhook receiver, the jars are only polled when it is empty
+	WebhookURL string `json:"webhookUrl,omitempty"`
+	JarName    string `json:"jarName"`
+	// JarID selects the jar by id instead of JarName
+	JarID    string `json:"jarId,omitempty"`
+	JarStart string `json:"jarStart"`
+	// JarEnd stops fetching the jar, the collection is open when empty
+	JarEnd string `json:"jarEnd,omitempty"`
+	// Sheet is the name of the jar sheet, the JarStart date when empty
+	Sheet      string      `json:"sheet,omitempty"`
+	Parsing    Parsing     `json:"parsing"`
+	Flats      []Flat      `json:"flats,omitempty"`
 	Exclusions []Exclusion `json:"exclusions"`
+	// Expenses are tried in order before the default categories
+	Expenses []ExpenseCategory `json:"expenses,omitempty"`
+	// ReconcileThreshold is the difference from the jar balance logged as an error
+	ReconcileThreshold money.Money `json:"reconcileThreshold"`
+	Campaigns          []Campaign  `json:"campaigns,omitempty"`
+	// Schedule of the service syncs, every minute when nil
+	Schedule *Schedule `json:"schedule,omitempty"`
+}
+
+// JarStartLayout is the layout of Config.JarStart.
+const JarStartLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// JarStartTime parses JarStart.
+func (c *Config) JarStartTime() (time.Time, error) {
+	return time.Parse(JarStartLayout, c.JarStart)
+}
+
+// SheetName returns the name of the jar sheet.
+func (c *Config) SheetName() (string, error) {
+	if c.Sheet != "" {
+		return c.Sheet, nil
+	}
+	if c.JarStart == "" {
+		return "", errors.New("invalid sheet name")
+	}
+	start, err := c.JarStartTime()
+	if err != nil {
+		return "", err
+	}
+	return start.Format("2006-01-02"), nil
+}
+
+// Suffixes of the sheets written next to the jar sheet.
+const (
+	BalanceSheetSuffix        = " balance"
+	ExpensesSheetSuffix       = " expenses"
+	SummarySheetSuffix        = " summary"
+	ReconciliationSheetSuffix = " reconciliation"
+)
+
+const (
+	// maxSheetName is the longest sheet name in characters xlsx accepts.
+	maxSheetName = 31
+	// sheetNameForbidden are the characters xlsx does not accept in sheet names.
+	sheetNameForbidden = `:\/?*[]`
+)
+
+// validSheetName reports why xlsx would not accept the jar sheet or one of the sheets next to it.
+func validSheetName(sheet string) error {
+	if strings.ContainsAny(sheet, sheetNameForbidden) {
+		return fmt.Errorf("sheet %s contains one of the characters %s", sheet, sheetNameForbidden)
+	}
+	longest := sheet
+	for _, suffix := range []string{BalanceSheetSuffix, ExpensesSheetSuffix, SummarySheetSuffix, ReconciliationSheetSuffix} {
+		if utf8.RuneCountInString(sheet+suffix) > utf8.RuneCountInString(longest) {
+			longest = sheet + suffix
+		}
+	}
+	if n := utf8.RuneCountInString(longest); n > maxSheetName {
+		return fmt.Errorf("sheet %s is too long, %q has %d characters and xlsx accepts %d",
+			sheet, longest, n, maxSheetName)
+	}
+	return nil
+}
+
+// JarEndTime parses JarEnd, it is zero for an open collection.
+func (c *Config) JarEndTime() (time.Time, error) {
+	if c.JarEnd == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(JarStartLayout, c.JarEnd)
 }
 
+// SetConfig replaces the config at path keeping backups of the previous versions.
 func SetConfig(path string, config Config) error {
+	l, err := safefile.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer func(l *safefile.Locker) {
+		_ = l.Unlock()
+	}(l)
+
+	return write(path, config)
+}
+
+func write(path string, config Config) error {
 	b, err := json.MarshalIndent(config, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(path, b, 0644)
+	return safefile.Save(path, b, 0644)
+}
+
+// update changes the config at path holding its lock, so concurrent writers do not lose changes.
+func update(path string, change func(config *Config) (bool, error)) error {
+	l, err := safefile.Lock(path)
+	if err != nil {
+		return err
+	}
+	defer func(l *safefile.Locker) {
+		_ = l.Unlock()
+	}(l)
+
+	config, err := GetConfig(path)
+	if err != nil {
+		return err
+	}
+	changed, err := change(config)
+	if err != nil || !changed {
+		return err
+	}
+	return write(path, *config)
 }
 
 func GetConfig(path string) (*Config, error) {
@@ -42,23 +206,44 @@ func GetConfig(path string) (*Config, error) {
 	return &c, nil
 }
 
-func AddExclusion(path string, e Exclusion) error {
-	config, err := GetConfig(path)
-	if err != nil {
-		return err
+// AddExclusions appends the exclusions whose transaction is not listed yet with a single write.
+func AddExclusions(path string, exclusions ...Exclusion) error {
+	if len(exclusions) == 0 {
+		return nil
 	}
+	return update(path, func(config *Config) (bool, error) {
+		changed := false
+		for _, e := range exclusions {
+			contains := slices.ContainsFunc(config.Exclusions, func(exclusion Exclusion) bool {
+				return e.TransactionID == exclusion.TransactionID
+			})
+			if contains {
+				continue
+			}
 
-	contains := slices.ContainsFunc(config.Exclusions, func(exclusion Exclusion) bool {
-		if e.TransactionID == exclusion.TransactionID {
-			return true
+			config.Exclusions = append(config.Exclusions, e)
+			changed = true
 		}
-		return false
+		return changed, nil
 	})
+}
 
-	if contains {
-		return nil
-	}
+// ResolveExclusion assigns the flat and card to the exclusion of the transaction.
+func ResolveExclusion(path string, transactionID string, flat int, card string) error {
+	return update(path, func(config *Config) (bool, error) {
+		i := slices.IndexFunc(config.Exclusions, func(exclusion Exclusion) bool {
+			return exclusion.TransactionID == transactionID
+		})
+		if i < 0 {
+			return false, fmt.Errorf("exclusion %s not found", transactionID)
+		}
 
-	config.Exclusions = append(config.Exclusions, e)
-	return SetConfig(path, *config)
+		config.Exclusions[i].Flat = flat
+		config.Exclusions[i].Card = card
+		// the service refuses an invalid config and keeps syncing with the last good one
+		if err := config.Validate(); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
 }
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"diesgen/money"
+	"slices"
+	"strings"
+)
+
+// Flat is a registered flat of the building with the payer details known for it.
+type Flat struct {
+	Number   int    `json:"number"`
+	Entrance int    `json:"entrance,omitempty"`
+	Floor    int    `json:"floor,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	// Cards are full or masked ("444111******3932") card numbers of the payers
+	Cards []string `json:"cards,omitempty"`
+	IBANs []string `json:"ibans,omitempty"`
+	// ExpectedMonthly is the contribution expected from the flat every month
+	ExpectedMonthly money.Money `json:"expectedMonthly"`
+}
+
+// FlatNumbers returns the numbers of the registered flats.
+func (c *Config) FlatNumbers() []int {
+	numbers := make([]int, 0, len(c.Flats))
+	for _, f := range c.Flats {
+		numbers = append(numbers, f.Number)
+	}
+	return numbers
+}
+
+// GetFlat returns the registered flat with the number.
+func (c *Config) GetFlat(number int) (*Flat, bool) {
+	i := slices.IndexFunc(c.Flats, func(f Flat) bool {
+		return f.Number == number
+	})
+	if i < 0 {
+		return nil, false
+	}
+	return &c.Flats[i], true
+}
+
+// Registered reports whether the flat can be attributed, every flat can without a flat registry.
+func (c *Config) Registered(number int) bool {
+	if len(c.Flats) == 0 {
+		return true
+	}
+	_, ok := c.GetFlat(number)
+	return ok
+}
+
+// FlatByIBAN returns the registered flat paying from the iban.
+func (c *Config) FlatByIBAN(iban string) (*Flat, bool) {
+	if iban == "" {
+		return nil, false
+	}
+	for i, f := range c.Flats {
+		if slices.ContainsFunc(f.IBANs, func(s string) bool {
+			return strings.EqualFold(strings.ReplaceAll(s, " ", ""), iban)
+		}) {
+			return &c.Flats[i], true
+		}
+	}
+	return nil, false
+}
+
+// FlatByCard returns the registered flat paying with the card, registered cards may be masked.
+func (c *Config) FlatByCard(card string) (*Flat, bool) {
+	if card == "" {
+		return nil, false
+	}
+	for i, f := range c.Flats {
+		if slices.ContainsFunc(f.Cards, func(s string) bool {
+			return cardMatches(s, card)
+		}) {
+			return &c.Flats[i], true
+		}
+	}
+	return nil, false
+}
+
+// cardMatches compares card numbers where either may hide digits behind '*'.
+func cardMatches(a string, b string) bool {
+	a = strings.ReplaceAll(a, " ", "")
+	b = strings.ReplaceAll(b, " ", "")
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] && a[i] != '*' && b[i] != '*' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Tenant is a building served by a multi-tenant instance with its own config, and so its own
+// token and jars, store and workbook. Relative paths are relative to the tenants file.
+type Tenant struct {
+	Name   string `json:"name"`
+	Config string `json:"config"`
+	// Xlsx and Store default to diesgen.xlsx and diesgen.db next to the config
+	Xlsx  string `json:"xlsx,omitempty"`
+	Store string `json:"store,omitempty"`
+}
+
+// GetTenants reads the tenants file with the paths made absolute.
+func GetTenants(path string) ([]Tenant, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []Tenant
+	err = json.Unmarshal(b, &tenants)
+	if err != nil {
+		return nil, fmt.Errorf("tenants %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	abs := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		t.Config = abs(t.Config)
+		t.Xlsx = abs(t.Xlsx)
+		t.Store = abs(t.Store)
+		if t.Config == "" {
+			continue
+		}
+		if t.Xlsx == "" {
+			t.Xlsx = filepath.Join(filepath.Dir(t.Config), "diesgen.xlsx")
+		}
+		if t.Store == "" {
+			t.Store = filepath.Join(filepath.Dir(t.Config), "diesgen.db")
+		}
+	}
+
+	err = validateTenants(tenants)
+	if err != nil {
+		return nil, fmt.Errorf("tenants %s: %w", path, err)
+	}
+	return tenants, nil
+}
+
+// validateTenants checks that every tenant is named and no file is shared by two tenants.
+func validateTenants(tenants []Tenant) error {
+	if len(tenants) == 0 {
+		return errors.New("no tenants")
+	}
+
+	var errs []error
+	names := make(map[string]bool)
+	files := make(map[string]int)
+	for i, t := range tenants {
+		if t.Name == "" {
+			errs = append(errs, errors.New("tenant name is empty"))
+		} else if names[t.Name] {
+			errs = append(errs, fmt.Errorf("tenant %s is listed twice", t.Name))
+		}
+		names[t.Name] = true
+
+		if t.Config == "" {
+			errs = append(errs, fmt.Errorf("tenant %s: config is empty", t.Name))
+			continue
+		}
+		for _, f := range []string{t.Config, t.Xlsx, t.Store} {
+			if other, ok := files[f]; ok && other != i {
+				errs = append(errs, fmt.Errorf("tenant %s: %s is used by tenant %s", t.Name, f, tenants[other].Name))
+			}
+			files[f] = i
+		}
+	}
+	return errors.Join(errs...)
+}
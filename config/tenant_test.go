@@ -0,0 +1,47 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGetTenants(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tenants.json")
+
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "Shevchenka 1", "config": "shevchenka1/config.json"},
+		{"name": "Franka 5", "config": "/srv/franka5/config.json", "xlsx": "franka5.xlsx", "store": "/srv/franka5/franka5.db"}
+	]`), 0644))
+	tenants, err := GetTenants(path)
+	require.NoError(t, err)
+	assert.Equal(t, []Tenant{
+		{
+			Name:   "Shevchenka 1",
+			Config: filepath.Join(dir, "shevchenka1", "config.json"),
+			Xlsx:   filepath.Join(dir, "shevchenka1", "diesgen.xlsx"),
+			Store:  filepath.Join(dir, "shevchenka1", "diesgen.db"),
+		},
+		{
+			Name:   "Franka 5",
+			Config: "/srv/franka5/config.json",
+			Xlsx:   filepath.Join(dir, "franka5.xlsx"),
+			Store:  "/srv/franka5/franka5.db",
+		},
+	}, tenants)
+
+	// the workbook of one building must not be written by another
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"name": "Shevchenka 1", "config": "shevchenka1/config.json", "xlsx": "diesgen.xlsx"},
+		{"name": "Shevchenka 1", "config": "franka5/config.json", "xlsx": "diesgen.xlsx"},
+		{"name": "", "config": ""}
+	]`), 0644))
+	_, err = GetTenants(path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "tenant Shevchenka 1 is listed twice")
+	assert.Contains(t, err.Error(), "diesgen.xlsx is used by tenant Shevchenka 1")
+	assert.Contains(t, err.Error(), "tenant name is empty")
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Validate reports every problem of the config that would make a sync fail or attribute
+// payments ambiguously. Comment rules are compiled by the rules package.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.XToken == "" {
+		errs = append(errs, errors.New("xToken is empty"))
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("webhookUrl: %w", err))
+		} else if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhookUrl %s is not an http url", c.WebhookURL))
+		}
+	}
+
+	campaigns := make(map[string]bool)
+	sheets := make(map[string]bool)
+	for _, cp := range c.CampaignList() {
+		prefix := ""
+		if len(c.Campaigns) > 0 {
+			prefix = fmt.Sprintf("campaign %s: ", cp.ID())
+		}
+		invalid := func(format string, a ...any) {
+			errs = append(errs, fmt.Errorf(prefix+format, a...))
+		}
+
+		if cp.JarName == "" && cp.JarID == "" {
+			invalid("jarName is empty")
+		}
+		if campaigns[cp.ID()] {
+			invalid("listed twice")
+		}
+		campaigns[cp.ID()] = true
+
+		v := c.For(cp)
+		start, err := v.JarStartTime()
+		if err != nil {
+			invalid("jarStart: %w", err)
+		}
+		end, err := v.JarEndTime()
+		if err != nil {
+			invalid("jarEnd: %w", err)
+		} else if !end.IsZero() && !end.After(start) {
+			invalid("jarEnd is not after jarStart")
+		}
+
+		if sheet, err := v.SheetName(); err == nil {
+			if err := validSheetName(sheet); err != nil {
+				invalid("%w", err)
+			}
+			key := cp.Xlsx + "\x00" + sheet
+			if sheets[key] {
+				invalid("sheet %s is used by another campaign", sheet)
+			}
+			sheets[key] = true
+		}
+	}
+
+	flats := make(map[int]bool)
+	ibans := make(map[string]int)
+	for _, f := range c.Flats {
+		if f.Number <= 0 {
+			errs = append(errs, fmt.Errorf("flat %d: number must be positive", f.Number))
+		}
+		if flats[f.Number] {
+			errs = append(errs, fmt.Errorf("flat %d: registered twice", f.Number))
+		}
+		flats[f.Number] = true
+
+		for _, iban := range f.IBANs {
+			key := strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+			if other, ok := ibans[key]; ok && other != f.Number {
+				errs = append(errs, fmt.Errorf("flat %d: iban %s is registered for flat %d", f.Number, iban, other))
+			}
+			ibans[key] = f.Number
+		}
+	}
+
+	transactions := make(map[string]bool)
+	for _, e := range c.Exclusions {
+		if transactions[e.TransactionID] {
+			errs = append(errs, fmt.Errorf("exclusion %s: listed twice", e.TransactionID))
+		}
+		transactions[e.TransactionID] = true
+		if e.Flat != 0 && !c.Registered(e.Flat) {
+			errs = append(errs, fmt.Errorf("exclusion %s: flat %d is not registered", e.TransactionID, e.Flat))
+		}
+	}
+
+	for i, e := range c.Expenses {
+		if e.Name == "" {
+			errs = append(errs, fmt.Errorf("expense category %d: name is empty", i+1))
+		}
+		if len(e.MCCs) == 0 && e.Pattern == "" {
+			errs = append(errs, fmt.Errorf("expense category %q: neither mccs nor pattern is set", e.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,70 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateSheetName(t *testing.T) {
+	for _, tc := range []struct {
+		sheet string
+		err   string
+	}{
+		{sheet: ""},
+		{sheet: "Diesel"},
+		// the longest name the reconciliation sheet fits
+		{sheet: "Generator repair"},
+		{sheet: "Generator repair autumn 2024", err: `"Generator repair autumn 2024 reconciliation" has 43 characters`},
+		// characters are counted, not bytes
+		{sheet: "Ремонт генератор"},
+		{sheet: "Ремонт генератора", err: "has 32 characters"},
+		{sheet: "Diesel 2024/25", err: "contains one of the characters"},
+		{sheet: "Diesel: autumn", err: "contains one of the characters"},
+		{sheet: "Diesel [old]", err: "contains one of the characters"},
+		{sheet: `Diesel\autumn`, err: "contains one of the characters"},
+		{sheet: "Diesel?", err: "contains one of the characters"},
+		{sheet: "Diesel*", err: "contains one of the characters"},
+	} {
+		c := Config{XToken: "token", JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST", Sheet: tc.sheet}
+		err := c.Validate()
+		if tc.err == "" {
+			assert.NoError(t, err, tc.sheet)
+			continue
+		}
+		require.Error(t, err, tc.sheet)
+		assert.Contains(t, err.Error(), tc.err, tc.sheet)
+	}
+
+	// campaigns are checked with their own sheets
+	c := Config{XToken: "token", Campaigns: []Campaign{
+		{JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST"},
+		{JarName: "Repair", JarStart: "2024-09-01 00:00:00 +0300 EEST", Sheet: "Generator repair autumn 2024"},
+	}}
+	err := c.Validate()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "campaign Repair: sheet Generator repair autumn 2024 is too long")
+}
+
+func TestResolveExclusionValidates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := SetConfig(path, Config{
+		XToken: "token", JarName: "Diesel", JarStart: "2024-06-25 11:00:00 +0300 EEST",
+		Flats:      []Flat{{Number: 12}},
+		Exclusions: []Exclusion{{TransactionID: "tx1"}},
+	})
+	require.NoError(t, err)
+
+	err = ResolveExclusion(path, "tx1", 13, "")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exclusion tx1: flat 13 is not registered")
+	c, err := GetConfig(path)
+	require.NoError(t, err)
+	assert.Zero(t, c.Exclusions[0].Flat)
+
+	require.NoError(t, ResolveExclusion(path, "tx1", 12, ""))
+	c, err = GetConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 12, c.Exclusions[0].Flat)
+}
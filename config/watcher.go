@@ -0,0 +1,132 @@
+package config
+
+import (
+	"diesgen/safefile"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"time"
+)
+
+// lastGoodSuffix is appended to the config path for the copy of the last valid version.
+const lastGoodSuffix = ".last-good"
+
+// Watcher polls a config file and keeps the last version that passed validation, so a
+// half-written or broken edit does not stop the service.
+type Watcher struct {
+	path     string
+	validate func(*Config) error
+
+	checked bool
+	modTime time.Time
+	size    int64
+	good    *Config
+	// valid is false while the file on disk fails to load or validate
+	valid bool
+}
+
+// NewWatcher returns a watcher of the config at path, validate is called for every new version.
+func NewWatcher(path string, validate func(*Config) error) *Watcher {
+	return &Watcher{path: path, validate: validate}
+}
+
+// Check reloads the file when it changed since the last call. It returns the previous and the new
+// config when a valid version was swapped in, both nil when nothing changed. An invalid version is
+// returned as an error once and the last known good one is kept. A failure to save the copy of
+// the last good version is returned together with the swapped configs.
+func (w *Watcher) Check() (prev *Config, next *Config, err error) {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		// reported once until the file is back
+		if w.checked && w.size < 0 {
+			return nil, nil, nil
+		}
+		w.checked, w.modTime, w.size, w.valid = true, time.Time{}, -1, false
+		return nil, nil, w.fallback(err)
+	}
+	if w.checked && info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return nil, nil, nil
+	}
+	w.checked, w.modTime, w.size = true, info.ModTime(), info.Size()
+
+	b, c, err := w.load(w.path)
+	if err != nil {
+		w.valid = false
+		return nil, nil, w.fallback(err)
+	}
+
+	prev, w.good, w.valid = w.good, c, true
+
+	err = safefile.WriteFile(w.LastGoodPath(), b, 0644)
+	if err != nil {
+		return prev, c, fmt.Errorf("save last good config: %w", err)
+	}
+	return prev, c, nil
+}
+
+// fallback loads the last good copy when no valid version was seen yet.
+func (w *Watcher) fallback(err error) error {
+	err = fmt.Errorf("config %s: %w", w.path, err)
+	if w.good != nil {
+		return fmt.Errorf("%w, keeping the last good version", err)
+	}
+
+	_, c, goodErr := w.load(w.LastGoodPath())
+	if goodErr != nil {
+		return err
+	}
+	w.good = c
+	return fmt.Errorf("%w, using %s", err, w.LastGoodPath())
+}
+
+func (w *Watcher) load(path string) ([]byte, *Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var c Config
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.validate != nil {
+		err = w.validate(&c)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return b, &c, nil
+}
+
+// Config returns the last valid config, nil when none was loaded.
+func (w *Watcher) Config() *Config {
+	return w.good
+}
+
+// Path returns the config path to sync with: the watched file while it is valid
+// and the copy of the last good version otherwise.
+func (w *Watcher) Path() (string, error) {
+	switch {
+	case w.valid:
+		return w.path, nil
+	case w.good != nil:
+		return w.LastGoodPath(), nil
+	default:
+		return "", errors.New("no valid config loaded")
+	}
+}
+
+// LastGoodPath returns the path of the copy of the last valid version.
+func (w *Watcher) LastGoodPath() string {
+	return w.path + lastGoodSuffix
+}
+
+// AttributionChanged reports whether the configs attribute payments differently.
+func AttributionChanged(a *Config, b *Config) bool {
+	return !reflect.DeepEqual(a.Parsing, b.Parsing) ||
+		!reflect.DeepEqual(a.Flats, b.Flats) ||
+		!reflect.DeepEqual(a.Exclusions, b.Exclusions) ||
+		!reflect.DeepEqual(a.Campaigns, b.Campaigns)
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	// files are rewritten within the mtime resolution, every version gets its own time
+	modTime := time.Now()
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		modTime = modTime.Add(time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	validate := func(c *Config) error {
+		if c.JarName == "" {
+			return errors.New("jarName is empty")
+		}
+		return nil
+	}
+
+	w := NewWatcher(path, validate)
+	_, err := w.Path()
+	assert.Error(t, err)
+
+	write(`{"jarName": "Diesel"}`)
+	prev, next, err := w.Check()
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+	require.NotNil(t, next)
+	assert.Equal(t, "Diesel", next.JarName)
+	assert.FileExists(t, w.LastGoodPath())
+
+	// unchanged
+	prev, next, err = w.Check()
+	require.NoError(t, err)
+	assert.Nil(t, prev)
+	assert.Nil(t, next)
+
+	// half written, the last good version is kept and reported once
+	write(`{"jarName": "Star`)
+	_, next, err = w.Check()
+	assert.Error(t, err)
+	assert.Nil(t, next)
+	assert.Equal(t, "Diesel", w.Config().JarName)
+	p, err := w.Path()
+	require.NoError(t, err)
+	assert.Equal(t, w.LastGoodPath(), p)
+	_, _, err = w.Check()
+	assert.NoError(t, err)
+
+	// invalid
+	write(`{"jarName": ""}`)
+	_, _, err = w.Check()
+	assert.ErrorContains(t, err, "jarName is empty")
+
+	write(`{"jarName": "Starlink", "exclusions": [{"transactionID": "a", "flat": 12}]}`)
+	prev, next, err = w.Check()
+	require.NoError(t, err)
+	assert.Equal(t, "Diesel", prev.JarName)
+	assert.Equal(t, "Starlink", next.JarName)
+	assert.True(t, AttributionChanged(prev, next))
+	p, err = w.Path()
+	require.NoError(t, err)
+	assert.Equal(t, path, p)
+
+	// a broken config at start falls back to the last good copy
+	write(`{`)
+	w = NewWatcher(path, validate)
+	_, _, err = w.Check()
+	assert.Error(t, err)
+	require.NotNil(t, w.Config())
+	assert.Equal(t, "Starlink", w.Config().JarName)
+	p, err = w.Path()
+	require.NoError(t, err)
+	assert.Equal(t, w.LastGoodPath(), p)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"diesgen/store"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// runCounterparties lists the learned payer to flat mappings and forgets the one given with -forget,
+// the workbook is attributed again then.
+func runCounterparties(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("counterparties")
+	forget := fs.String("forget", "", "payer key of the mapping to forget, it is learned again from later payments only")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+	o := g.options()
+
+	if *forget == "" {
+		return printCounterparties(o.StorePath, out)
+	}
+
+	err := forgetCounterparty(o.StorePath, *forget)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(out, "%s forgotten\n", *forget)
+	return reattributeWorkbook(o)
+}
+
+func forgetCounterparty(storePath string, key string) error {
+	st, err := store.Open(storePath)
+	if err != nil {
+		return err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	found, err := st.ForgetCounterparty(key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no learned payer %q, see the counterparties command", key)
+	}
+	return nil
+}
+
+func printCounterparties(storePath string, out io.Writer) error {
+	st, err := store.Open(storePath)
+	if err != nil {
+		return err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	counterparties, err := st.Counterparties()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PAYER\tFLAT\tLEARNED FROM\tUPDATED")
+	for _, c := range counterparties {
+		flat := strconv.Itoa(c.Flat)
+		switch {
+		case c.Ambiguous:
+			flat = "ambiguous"
+		case c.Forgotten():
+			flat = "forgotten"
+		}
+		from := c.TransactionID
+		if from == "" {
+			from = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Key, flat, from, c.UpdatedAt.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/service"
+	"diesgen/store"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// errNotRegistered is returned when a flat that is not in the registry is assigned.
+var errNotRegistered = errors.New("flat is not registered")
+
+// unresolved is an exclusion without a flat together with its stored transaction.
+type unresolved struct {
+	exclusion config.Exclusion
+	record    *store.Record
+}
+
+func (u unresolved) transaction() api.Transaction {
+	if u.record != nil {
+		return u.record.Transaction
+	}
+	// exclusions added before the store existed
+	return api.Transaction{
+		ID:           u.exclusion.TransactionID,
+		Comment:      u.exclusion.Comment,
+		Amount:       int(u.exclusion.Amount.Amount),
+		CurrencyCode: u.exclusion.Amount.Currency,
+	}
+}
+
+// runExclusions lists exclusions the service could not attribute and assigns flats to them,
+// either the one given with -id and -flat or interactively one by one.
+func runExclusions(args []string, in io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("exclusions")
+	list := fs.Bool("list", false, "list unresolved exclusions and exit")
+	id := fs.String("id", "", "transaction id of the exclusion to resolve")
+	flat := fs.Int("flat", 0, "flat to assign to the exclusion given with -id")
+	card := fs.String("card", "", "card to assign to the exclusion given with -id")
+	if err := g.parse(fs, args, os.Stderr); err != nil {
+		return err
+	}
+	o := g.options()
+
+	pending, err := unresolvedExclusions(o)
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		return printUnresolved(out, pending)
+	}
+
+	var resolved []api.Transaction
+	if *id != "" {
+		if *flat <= 0 {
+			return errors.New("-flat is required with -id")
+		}
+		for _, u := range pending {
+			if u.exclusion.TransactionID == *id {
+				resolved = append(resolved, u.transaction())
+			}
+		}
+		if len(resolved) == 0 {
+			return fmt.Errorf("exclusion %s is not unresolved", *id)
+		}
+		err = resolveExclusion(o, *id, *flat, *card)
+		if err != nil {
+			return err
+		}
+	} else {
+		resolved, err = resolveInteractively(o, pending, in, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(resolved) == 0 {
+		return nil
+	}
+	return reattributeWorkbook(o)
+}
+
+func unresolvedExclusions(o service.Options) ([]unresolved, error) {
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	var pending []unresolved
+	for _, e := range c.Exclusions {
+		if e.Flat != 0 {
+			continue
+		}
+		r, err := st.Find(e.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+		// attributed by the registry or a learned payer meanwhile
+		if r != nil && r.Source != store.SourceUnknown {
+			continue
+		}
+		pending = append(pending, unresolved{exclusion: e, record: r})
+	}
+	return pending, nil
+}
+
+func printUnresolved(out io.Writer, pending []unresolved) error {
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "TRANSACTION\tTIME\tAMOUNT\tCOMMENT\tCOUNTERPARTY")
+	for _, u := range pending {
+		_, _ = fmt.Fprintln(tw, describe(u))
+	}
+	return tw.Flush()
+}
+
+func describe(u unresolved) string {
+	t := u.transaction()
+	when := "-"
+	if t.Time != 0 {
+		when = time.Unix(t.Time, 0).Format(time.DateTime)
+	}
+	counterparty := strings.TrimSpace(t.CounterName + " " + t.CounterIban)
+	if counterparty == "" {
+		counterparty = "-"
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%q\t%s", t.ID, when, t.Money(), t.Comment, counterparty)
+}
+
+// resolveInteractively asks for the flat and card of every pending exclusion.
+func resolveInteractively(o service.Options, pending []unresolved, in io.Reader, out io.Writer) ([]api.Transaction, error) {
+	var resolved []api.Transaction
+	reader := bufio.NewReader(in)
+	for i, u := range pending {
+		_, _ = fmt.Fprintf(out, "\n[%d/%d] %s\n", i+1, len(pending), strings.ReplaceAll(describe(u), "\t", "  "))
+		_, _ = fmt.Fprint(out, "flat[:card] (empty to skip, q to quit): ")
+
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return resolved, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "q" || (line == "" && errors.Is(err, io.EOF)) {
+			break
+		}
+		if line == "" {
+			continue
+		}
+
+		flatValue, cardValue, _ := strings.Cut(line, ":")
+		flat, convErr := strconv.Atoi(strings.TrimSpace(flatValue))
+		if convErr != nil || flat <= 0 {
+			_, _ = fmt.Fprintf(out, "invalid flat %q, skipped\n", flatValue)
+			continue
+		}
+
+		err = resolveExclusion(o, u.exclusion.TransactionID, flat, strings.TrimSpace(cardValue))
+		if errors.Is(err, errNotRegistered) {
+			_, _ = fmt.Fprintf(out, "%v, skipped\n", err)
+			continue
+		}
+		if err != nil {
+			return resolved, err
+		}
+		resolved = append(resolved, u.transaction())
+	}
+	return resolved, nil
+}
+
+// resolveExclusion assigns the flat in the config and the store.
+func resolveExclusion(o service.Options, transactionID string, flat int, card string) error {
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return err
+	}
+	if !c.Registered(flat) {
+		return fmt.Errorf("%w: %d in %s", errNotRegistered, flat, o.ConfigPath)
+	}
+
+	err = config.ResolveExclusion(o.ConfigPath, transactionID, flat, card)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	r, err := st.Find(transactionID)
+	if err != nil || r == nil {
+		return err
+	}
+	r.Flat = flat
+	r.Card = card
+	r.Source = store.SourceExclusion
+	r.Rule = ""
+	return st.Put(*r)
+}
+
+// reattributeWorkbook regenerates the workbooks so the resolved transactions move from
+// the unknown row to their flats.
+func reattributeWorkbook(o service.Options) error {
+	err := service.RebuildWorkbook(context.Background(), o)
+	if errors.Is(err, service.ErrNotSynced) {
+		// the next sync creates it from the store
+		return nil
+	}
+	return err
+}
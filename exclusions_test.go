@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/service"
+	"diesgen/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newExclusionsTenant returns the flags of a tenant with two unresolved exclusions, tx1 stored
+// by the service and tx2 added before the store existed.
+func newExclusionsTenant(t *testing.T) []string {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.json")
+	storePath := filepath.Join(dir, "diesgen.db")
+
+	err := config.SetConfig(configPath, config.Config{
+		XToken:   "token",
+		JarName:  "Diesel",
+		JarStart: "2024-06-25 11:00:00 +0300 EEST",
+		Flats:    []config.Flat{{Number: 12}, {Number: 14}},
+		Exclusions: []config.Exclusion{
+			{TransactionID: "tx1", Comment: "for diesel", Amount: money.New(50000, 980)},
+			{TransactionID: "tx2", Comment: "дизель", Amount: money.New(20000, 980)},
+		},
+	})
+	require.NoError(t, err)
+
+	st, err := store.Open(storePath)
+	require.NoError(t, err)
+	err = st.Put(store.Record{
+		Account: "jar1",
+		Transaction: api.Transaction{
+			ID: "tx1", Time: time.Date(2024, 7, 1, 10, 0, 0, 0, time.Local).Unix(),
+			Comment: "for diesel", Amount: 50000, CurrencyCode: 980, CounterName: "Ivan P.",
+		},
+		Source: store.SourceUnknown,
+	})
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+
+	return []string{
+		"-config", configPath,
+		"-xlsx", filepath.Join(dir, "diesgen.xlsx"),
+		"-store", storePath,
+		"-log", filepath.Join(dir, "diesgen.log"),
+	}
+}
+
+func TestExclusionsList(t *testing.T) {
+	flags := newExclusionsTenant(t)
+
+	var out bytes.Buffer
+	require.NoError(t, runExclusions(append(flags, "-list"), nil, &out))
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], "TRANSACTION")
+	assert.Contains(t, lines[1], "tx1")
+	assert.Contains(t, lines[1], "2024-07-01 10:00:00")
+	assert.Contains(t, lines[1], "Ivan P.")
+	assert.Contains(t, lines[2], "tx2")
+	assert.Contains(t, lines[2], `"дизель"`)
+}
+
+func TestExclusionsResolve(t *testing.T) {
+	flags := newExclusionsTenant(t)
+	o := options(t, flags)
+
+	require.NoError(t, runExclusions(append(flags, "-id", "tx1", "-flat", "12", "-card", "4441"), nil, &bytes.Buffer{}))
+
+	c, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, 12, c.Exclusions[0].Flat)
+	assert.Equal(t, "4441", c.Exclusions[0].Card)
+	assert.Zero(t, c.Exclusions[1].Flat)
+
+	r := find(t, o.StorePath, "tx1")
+	assert.Equal(t, 12, r.Flat)
+	assert.Equal(t, "4441", r.Card)
+	assert.Equal(t, store.SourceExclusion, r.Source)
+
+	// only tx2 is left
+	var out bytes.Buffer
+	require.NoError(t, runExclusions(append(flags, "-list"), nil, &out))
+	assert.NotContains(t, out.String(), "tx1")
+	assert.Contains(t, out.String(), "tx2")
+}
+
+func TestExclusionsResolveUnregisteredFlat(t *testing.T) {
+	flags := newExclusionsTenant(t)
+	o := options(t, flags)
+	before, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+
+	err = runExclusions(append(flags, "-id", "tx1", "-flat", "13"), nil, &bytes.Buffer{})
+	require.ErrorIs(t, err, errNotRegistered)
+
+	after, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, before.Exclusions, after.Exclusions)
+	assert.Equal(t, store.SourceUnknown, find(t, o.StorePath, "tx1").Source)
+
+	// interactively the flat is skipped and the next one is asked
+	var out bytes.Buffer
+	require.NoError(t, runExclusions(flags, strings.NewReader("13\n14\n"), &out))
+	assert.Contains(t, out.String(), "flat is not registered: 13")
+
+	after, err = config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	assert.Zero(t, after.Exclusions[0].Flat)
+	assert.Equal(t, 14, after.Exclusions[1].Flat)
+}
+
+func options(t *testing.T, flags []string) service.Options {
+	fs, g := newFlagSet("test")
+	require.NoError(t, g.parse(fs, flags, &bytes.Buffer{}))
+	return g.options()
+}
+
+func find(t *testing.T, path, transactionID string) *store.Record {
+	st, err := store.Open(path)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	r, err := st.Find(transactionID)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	return r
+}
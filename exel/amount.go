@@ -0,0 +1,22 @@
+package exel
+
+import (
+	"diesgen/money"
+	"github.com/tealeg/xlsx"
+)
+
+const amountFormat = "#,##0.00"
+
+var currencyFormats = map[int]string{
+	money.UAH: amountFormat + ` "₴"`,
+	money.USD: `"$"` + amountFormat,
+	money.EUR: amountFormat + ` "€"`,
+}
+
+func setAmount(cell *xlsx.Cell, m money.Money) {
+	format, ok := currencyFormats[m.Currency]
+	if !ok {
+		format = amountFormat
+	}
+	cell.SetFloatWithFormat(m.Float(), format)
+}
@@ -0,0 +1,98 @@
+package exel
+
+import (
+	"context"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/store"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"time"
+)
+
+// WriteBalanceSheet regenerates the balance of every registered flat: the amount paid
+// against the monthly contribution expected since the jar start. It does nothing without a flat registry.
+func WriteBalanceSheet(ctx context.Context, file *xlsx.File, records []store.Record, c *config.Config, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(c.Flats) == 0 {
+		return nil
+	}
+
+	sname, err := c.SheetName()
+	if err != nil {
+		return err
+	}
+	start, err := c.JarStartTime()
+	if err != nil {
+		return err
+	}
+	now, err = campaignNow(c, now)
+	if err != nil {
+		return err
+	}
+	months := monthsSince(start, now)
+
+	paid := make(map[int]money.Money)
+	for _, r := range records {
+		if r.Transaction.Amount < 0 {
+			continue
+		}
+		paid[r.Flat], err = paid[r.Flat].Add(r.Transaction.Money())
+		if err != nil {
+			return fmt.Errorf("flat %d: %w", r.Flat, err)
+		}
+	}
+
+	sheet, err := resetSheet(file, sname+config.BalanceSheetSuffix)
+	if err != nil {
+		return err
+	}
+
+	header := sheet.AddRow()
+	for _, column := range []string{"Flat", "Entrance", "Floor", "Owner", "Paid", "Expected", "Balance"} {
+		header.AddCell().Value = column
+	}
+
+	for _, f := range c.Flats {
+		expected := money.New(f.ExpectedMonthly.Amount*int64(months), f.ExpectedMonthly.Currency)
+		p, ok := paid[f.Number]
+		if !ok {
+			p = money.New(0, expected.Currency)
+		}
+		balance, err := p.Sub(expected)
+		if err != nil {
+			return fmt.Errorf("flat %d: %w", f.Number, err)
+		}
+
+		row := sheet.AddRow()
+		row.AddCell().SetInt(f.Number)
+		row.AddCell().SetInt(f.Entrance)
+		row.AddCell().SetInt(f.Floor)
+		row.AddCell().Value = f.Owner
+		setAmount(row.AddCell(), p)
+		setAmount(row.AddCell(), expected)
+		setAmount(row.AddCell(), balance)
+	}
+	return nil
+}
+
+// monthsSince returns the number of calendar months started from start to now, including both.
+func monthsSince(start time.Time, now time.Time) int {
+	now = now.In(start.Location())
+	months := (now.Year()-start.Year())*12 + int(now.Month()-start.Month()) + 1
+	return max(months, 1)
+}
+
+// campaignNow returns the end of a closed campaign when it is before now.
+func campaignNow(c *config.Config, now time.Time) (time.Time, error) {
+	end, err := c.JarEndTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !end.IsZero() && end.Before(now) {
+		return end, nil
+	}
+	return now, nil
+}
@@ -0,0 +1,43 @@
+package exel
+
+import (
+	"context"
+	"diesgen/store"
+	"github.com/tealeg/xlsx"
+	"time"
+)
+
+const counterpartiesSheet = "Counterparties"
+
+// WriteCounterpartiesSheet lists the learned payer to flat mappings for review.
+func WriteCounterpartiesSheet(ctx context.Context, file *xlsx.File, counterparties []store.Counterparty) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(counterparties) == 0 {
+		return nil
+	}
+
+	sheet, err := resetSheet(file, counterpartiesSheet)
+	if err != nil {
+		return err
+	}
+
+	header := sheet.AddRow()
+	for _, column := range []string{"Payer", "Flat", "Ambiguous", "Learned from", "Updated"} {
+		header.AddCell().Value = column
+	}
+
+	for _, c := range counterparties {
+		if c.Forgotten() {
+			continue
+		}
+		row := sheet.AddRow()
+		row.AddCell().Value = c.Key
+		row.AddCell().SetInt(c.Flat)
+		row.AddCell().SetBool(c.Ambiguous)
+		row.AddCell().Value = c.TransactionID
+		row.AddCell().Value = c.UpdatedAt.Format(time.DateTime)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package exel
+
+import (
+	"context"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/store"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"time"
+)
+
+const periodLayout = "2006-01"
+
+// WriteExpensesSheet regenerates the ledger of the jar withdrawals with their categories.
+func WriteExpensesSheet(ctx context.Context, file *xlsx.File, records []store.Record, c *config.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	categories, err := rules.ExpensesFromConfig(c)
+	if err != nil {
+		return err
+	}
+	start, err := c.JarStartTime()
+	if err != nil {
+		return err
+	}
+	sname, err := c.SheetName()
+	if err != nil {
+		return err
+	}
+
+	sheet, err := resetSheet(file, sname+config.ExpensesSheetSuffix)
+	if err != nil {
+		return err
+	}
+
+	header := sheet.AddRow()
+	for _, column := range []string{"Time", "Amount", "Category", "Description", "Comment", "MCC", "Transaction"} {
+		header.AddCell().Value = column
+	}
+
+	for _, r := range records {
+		t := r.Transaction
+		if t.Amount >= 0 {
+			continue
+		}
+		row := sheet.AddRow()
+		row.AddCell().Value = time.Unix(t.Time, 0).In(start.Location()).Format(time.DateTime)
+		setAmount(row.AddCell(), t.Money().Neg())
+		row.AddCell().Value = categories.Category(t)
+		row.AddCell().Value = t.Description
+		row.AddCell().Value = t.Comment
+		row.AddCell().SetInt(t.MCC)
+		row.AddCell().Value = t.ID
+	}
+	return nil
+}
+
+// WriteSummarySheet regenerates the income, spending and the remaining balance of the jar
+// for every month from the jar start to now or the jar end.
+func WriteSummarySheet(ctx context.Context, file *xlsx.File, records []store.Record, c *config.Config, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	start, err := c.JarStartTime()
+	if err != nil {
+		return err
+	}
+	now, err = campaignNow(c, now)
+	if err != nil {
+		return err
+	}
+	sname, err := c.SheetName()
+	if err != nil {
+		return err
+	}
+
+	type period struct {
+		income   money.Money
+		spending money.Money
+	}
+	periods := make(map[string]*period)
+	for _, r := range records {
+		key := time.Unix(r.Transaction.Time, 0).In(start.Location()).Format(periodLayout)
+		p, ok := periods[key]
+		if !ok {
+			p = &period{}
+			periods[key] = p
+		}
+		if r.Transaction.Amount < 0 {
+			p.spending, err = p.spending.Sub(r.Transaction.Money())
+		} else {
+			p.income, err = p.income.Add(r.Transaction.Money())
+		}
+		if err != nil {
+			return fmt.Errorf("period %s: %w", key, err)
+		}
+	}
+
+	sheet, err := resetSheet(file, sname+config.SummarySheetSuffix)
+	if err != nil {
+		return err
+	}
+
+	header := sheet.AddRow()
+	for _, column := range []string{"Period", "Income", "Spending", "Balance"} {
+		header.AddCell().Value = column
+	}
+
+	var balance money.Money
+	months := monthsSince(start, now)
+	for i := 0; i < months; i++ {
+		key := time.Date(start.Year(), start.Month()+time.Month(i), 1, 0, 0, 0, 0, start.Location()).Format(periodLayout)
+		p, ok := periods[key]
+		if !ok {
+			p = &period{}
+		}
+		balance, err = balance.Add(p.income)
+		if err != nil {
+			return fmt.Errorf("period %s: %w", key, err)
+		}
+		balance, err = balance.Sub(p.spending)
+		if err != nil {
+			return fmt.Errorf("period %s: %w", key, err)
+		}
+
+		row := sheet.AddRow()
+		row.AddCell().Value = key
+		setAmount(row.AddCell(), money.New(p.income.Amount, p.income.Currency))
+		setAmount(row.AddCell(), money.New(p.spending.Amount, p.spending.Currency))
+		setAmount(row.AddCell(), money.New(balance.Amount, balance.Currency))
+	}
+	return nil
+}
+
+// resetSheet returns the sheet with the name emptied, it is added when missing.
+func resetSheet(file *xlsx.File, name string) (*xlsx.Sheet, error) {
+	sheet := file.Sheet[name]
+	if sheet == nil {
+		var err error
+		sheet, err = file.AddSheet(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sheet.Rows = nil
+	sheet.MaxRow = 0
+	return sheet, nil
+}
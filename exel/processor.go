@@ -1,222 +1,136 @@
 package exel
 
 import (
-	"cmp"
+	"context"
 	"diesgen/api"
 	"diesgen/config"
-	"errors"
+	"diesgen/logging"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/store"
 	"fmt"
-	log "github.com/sirupsen/logrus"
 	"github.com/tealeg/xlsx"
-	"math"
-	"regexp"
 	"slices"
-	"strconv"
 	"strings"
-	"time"
 )
 
 type FlatAndCard struct {
 	Card string
 	Flat int
+	// Rule is the name of the comment rule the pair was parsed with
+	Rule string
 }
 
-const flatIndex = 0
-const amountIndex = 1
-const transactionIndex = 2
+// Resolver is an additional attribution step tried when the config can not resolve a transaction.
+type Resolver func(api.Transaction) (*FlatAndCard, store.Source, bool)
 
-func ProcessStatement(file *xlsx.File, statement []api.Transaction, confPath string) error {
-	sname, err := sheetName(confPath)
-	if err != nil {
-		return err
+// Resolve resolves the flat and card of the transaction from its comment, a resolved exclusion,
+// the payer cards and IBANs of the flat registry and the resolvers, in that order. It does not
+// change the config, unresolved transactions get the pair of their exclusion or of UnknownExclusion.
+func Resolve(c *config.Config, engine *rules.Engine, transaction api.Transaction, resolvers ...Resolver) (*FlatAndCard, store.Source) {
+	if pair, err := flatAndCard(engine, transaction.Comment); err == nil {
+		return pair, store.SourceComment
 	}
 
-	sheet, err := getSheet(file, sname)
-	if err != nil {
-		return err
+	exclusionPair, excluded := getExclusion(c.Exclusions, transaction)
+	if excluded && exclusionPair.Flat != 0 {
+		return exclusionPair, store.SourceExclusion
 	}
 
-	flatIndexMap := getFlatToCellIndexMap(sheet)
-
-	for _, transaction := range statement {
-		transactionPair, err := flatAndCard(transaction.Comment)
-
-		var exclusionPair *FlatAndCard
-		if err != nil {
-			exclusionPair, err = processFlatAndCardErr(confPath, transaction)
-			if err != nil {
-				return err
-			}
-		}
-
-		// the case when statement contains already saved transactions
-		if transactionIDExists(sheet, transaction.ID) {
-			if (transactionPair == nil || transactionPair.Flat == 0) &&
-				(exclusionPair != nil && exclusionPair.Flat != 0) {
-				updateUnknownTransactions(sheet, transaction)
-			}
-
-			if transactionIDExists(sheet, transaction.ID) {
-				continue
-			}
-		}
+	if pair, ok := registryFlat(c, transaction); ok {
+		return pair, store.SourceRegistry
+	}
 
-		if transactionPair == nil {
-			transactionPair = exclusionPair
+	for _, resolve := range resolvers {
+		if pair, source, ok := resolve(transaction); ok {
+			return pair, source
 		}
+	}
 
-		updateSheet(sheet, flatIndexMap, transaction, transactionPair)
+	if excluded {
+		return exclusionPair, store.SourceUnknown
 	}
+	e := UnknownExclusion(transaction)
+	return &FlatAndCard{Card: e.Card, Flat: e.Flat}, store.SourceUnknown
+}
 
-	return nil
+// UnknownExclusion is the exclusion added for a transaction nothing could attribute.
+func UnknownExclusion(transaction api.Transaction) config.Exclusion {
+	return config.Exclusion{Card: "Unknown",
+		Comment:       transaction.Comment,
+		TransactionID: transaction.ID,
+		Amount:        transaction.Money()}
 }
 
-func SortMainTable(file *xlsx.File, confPath string) error {
-	sname, err := sheetName(confPath)
-	if err != nil {
-		return err
+func registryFlat(c *config.Config, transaction api.Transaction) (*FlatAndCard, bool) {
+	if f, ok := c.FlatByIBAN(transaction.CounterIban); ok {
+		return &FlatAndCard{Flat: f.Number}, true
 	}
-
-	sheet, err := getSheet(file, sname)
-	if err != nil {
-		return err
+	card := rules.Card(transaction.Comment)
+	if f, ok := c.FlatByCard(card); ok {
+		return &FlatAndCard{Flat: f.Number, Card: card}, true
 	}
-
-	slices.SortFunc(sheet.Rows, func(a, b *xlsx.Row) int {
-		aFlat, err := a.Cells[flatIndex].Int()
-		if err != nil {
-			return -1
-		}
-		bFlat, err := b.Cells[flatIndex].Int()
-		if err != nil {
-			return 1
-		}
-
-		return cmp.Compare(aFlat, bFlat)
-	})
-	return nil
+	return nil, false
 }
 
-func CleanZeroAmountValues(file *xlsx.File, confPath string) error {
-	sname, err := sheetName(confPath)
+// WriteSheet regenerates the jar sheet of the campaign config from the stored records ordered
+// by flat, withdrawals are skipped since WriteExpensesSheet lists them.
+func WriteSheet(ctx context.Context, file *xlsx.File, records []store.Record, c *config.Config) error {
+	sname, err := c.SheetName()
 	if err != nil {
 		return err
 	}
 
-	sheet, err := getSheet(file, sname)
+	sheet, err := getSheet(ctx, file, sname)
 	if err != nil {
 		return err
 	}
 
-	if len(sheet.Rows) < 2 {
-		return nil
-	}
-
-	zeroFlatRaw := sheet.Rows[1]
-	if zeroFlatRaw == nil {
-		return nil
-	}
-
-	if v, err := zeroFlatRaw.Cells[flatIndex].Int(); err != nil || v != 0 {
-		return nil
-	}
+	// keep the header only
+	sheet.Rows = sheet.Rows[:min(len(sheet.Rows), 1)]
+	sheet.MaxRow = len(sheet.Rows)
 
-	if v, err := zeroFlatRaw.Cells[amountIndex].Int(); err != nil || v != 0 {
-		return nil
+	type flatRow struct {
+		paid         money.Money
+		transactions []string
 	}
-
-	sheet.Rows = append(sheet.Rows[:1], sheet.Rows[2:]...)
-	return nil
-}
-
-func updateUnknownTransactions(sheet *xlsx.Sheet, tr api.Transaction) (updated bool) {
-	for _, row := range sheet.Rows {
-		s := row.Cells[transactionIndex].String()
-		transactions := strings.Split(s, ",")
-		if !slices.Contains(transactions, tr.ID) {
-			continue
+	rows := make(map[int]*flatRow)
+	for i, r := range records {
+		if i%1000 == 0 && ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		if flat, _ := row.Cells[flatIndex].Int(); flat != 0 {
+		if r.Transaction.Amount < 0 {
 			continue
 		}
-
-		updatedTransactions := slices.DeleteFunc(transactions, func(s string) bool {
-			return s == tr.ID
-		})
-
-		row.Cells[transactionIndex].SetString(strings.Join(updatedTransactions, ","))
-		amount, _ := row.Cells[amountIndex].Int()
-		row.Cells[amountIndex].SetInt(amount - (tr.Amount / 100))
-	}
-
-	return false
-}
-
-func sheetName(confPath string) (string, error) {
-	c, err := config.GetConfig(confPath)
-	if err != nil {
-		return "", err
-	}
-
-	if c.JarStart == "" {
-		return "", errors.New("invalid sheet name")
+		row, ok := rows[r.Flat]
+		if !ok {
+			row = &flatRow{}
+			rows[r.Flat] = row
+		}
+		row.paid, err = row.paid.Add(r.Transaction.Money())
+		if err != nil {
+			return fmt.Errorf("flat %d: %w", r.Flat, err)
+		}
+		row.transactions = append(row.transactions, r.Transaction.ID)
 	}
 
-	layout := "2006-01-02 15:04:05 -0700 MST"
-	t, err := time.Parse(layout, c.JarStart)
-	if err != nil {
-		return "", err
+	flats := make([]int, 0, len(rows))
+	for flat := range rows {
+		flats = append(flats, flat)
 	}
-
-	outputLayout := "2006-01-02"
-	return t.Format(outputLayout), nil
-}
-
-func updateSheet(sheet *xlsx.Sheet, flatIndexMap map[int]int, transaction api.Transaction, pair *FlatAndCard) {
-	transactionAmount := float64(transaction.Amount / 100)
-
-	if rowIndex, found := flatIndexMap[pair.Flat]; found {
-		// Update existing row
-		currentAmount, _ := strconv.ParseFloat(sheet.Rows[rowIndex].Cells[1].String(), 64)
-		sheet.Rows[rowIndex].Cells[amountIndex].SetInt(int(math.Floor(currentAmount) + math.Floor(transactionAmount)))
-		sheet.Rows[rowIndex].Cells[transactionIndex].Value += "," + transaction.ID
-	} else {
-		// Add new row
+	slices.Sort(flats)
+	for _, flat := range flats {
+		r := rows[flat]
+		// the unknown flat row is left out once all its payments are resolved
+		if flat == 0 && r.paid.IsZero() {
+			continue
+		}
 		row := sheet.AddRow()
-		row.AddCell().SetInt(pair.Flat)
-		row.AddCell().SetInt(int(math.Floor(transactionAmount)))
-		row.AddCell().Value = transaction.ID
-		flatIndexMap[pair.Flat] = sheet.MaxRow - 1
-	}
-}
-
-func processFlatAndCardErr(confPath string, transaction api.Transaction) (*FlatAndCard, error) {
-	c, err := config.GetConfig(confPath)
-	if err != nil {
-		return nil, err
-	}
-
-	pair, ok := getExclusion(c.Exclusions, transaction)
-	if ok {
-		return pair, nil
-	}
-
-	log.Errorf("invalid comment: %s tr: %s", transaction.Comment, transaction.ID)
-
-	e := config.Exclusion{Card: "Unknown",
-		Comment:       transaction.Comment,
-		TransactionID: transaction.ID,
-		Amount:        transaction.Amount / 100}
-	err = config.AddExclusion(confPath, e)
-	if err != nil {
-		return nil, err
+		row.AddCell().SetInt(flat)
+		setAmount(row.AddCell(), r.paid)
+		row.AddCell().Value = strings.Join(r.transactions, ",")
 	}
-
-	pair.Card = e.Card
-	pair.Flat = e.Flat
-
-	return pair, nil
+	return nil
 }
 
 func getExclusion(exclusions []config.Exclusion, transaction api.Transaction) (*FlatAndCard, bool) {
@@ -232,32 +146,14 @@ func getExclusion(exclusions []config.Exclusion, transaction api.Transaction) (*
 	return &pair, ok
 }
 
-func getFlatToCellIndexMap(sheet *xlsx.Sheet) map[int]int {
-	flatIndex := make(map[int]int)
-	for i, row := range sheet.Rows[1:] {
-		if len(row.Cells) == 0 {
-			// skip empty rows
-			continue
-		}
-
-		flat, err := strconv.Atoi(row.Cells[0].String())
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-		flatIndex[flat] = i + 1
-	}
-	return flatIndex
-}
-
-func getSheet(file *xlsx.File, sheetName string) (*xlsx.Sheet, error) {
+func getSheet(ctx context.Context, file *xlsx.File, sheetName string) (*xlsx.Sheet, error) {
 	const flatColumn = "Flat"
 	const amountColumn = "Amount"
 	const transactionsColumn = "Transactions"
 
 	sheet := file.Sheet[sheetName]
 	if sheet == nil {
-		log.Infof("adding sheet: %s", sheetName)
+		logging.Entry(ctx).Infof("adding sheet: %s", sheetName)
 
 		var err error
 		sheet, err = file.AddSheet(sheetName)
@@ -273,38 +169,10 @@ func getSheet(file *xlsx.File, sheetName string) (*xlsx.Sheet, error) {
 	return sheet, nil
 }
 
-func flatAndCard(s string) (*FlatAndCard, error) {
-	// regular expression to match sequences of digits
-	re := regexp.MustCompile(`\d+`)
-
-	matches := re.FindAllString(s, -1)
-	if len(matches) == 0 {
-		return nil, errors.New("input does not contain flat or card")
-	}
-
-	flat, err := strconv.Atoi(matches[0])
+func flatAndCard(engine *rules.Engine, s string) (*FlatAndCard, error) {
+	m, err := engine.Parse(s)
 	if err != nil {
-		return nil, fmt.Errorf("invalid flat number: %w", err)
-	}
-	if len(strconv.Itoa(flat)) > 4 {
-		return nil, fmt.Errorf("invalid flat number: %w", err)
-	}
-
-	var card string
-	if len(matches) > 1 {
-		card = matches[1]
-	}
-
-	return &FlatAndCard{Card: card, Flat: flat}, nil
-}
-
-func transactionIDExists(sheet *xlsx.Sheet, transactionID string) bool {
-	for _, row := range sheet.Rows {
-		s := row.Cells[transactionIndex].String()
-		transactions := strings.Split(s, ",")
-		if slices.Contains(transactions, transactionID) {
-			return true
-		}
+		return nil, err
 	}
-	return false
+	return &FlatAndCard{Card: m.Card, Flat: m.Flat, Rule: m.Rule}, nil
 }
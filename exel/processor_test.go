@@ -1,8 +1,12 @@
 package exel
 
 import (
+	"context"
 	"diesgen/api"
 	"diesgen/config"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/store"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -10,24 +14,25 @@ import (
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestFindFlatAndCard(t *testing.T) {
-	pair, err := flatAndCard("155 4441114420563932")
+	pair, err := flatAndCard(rules.Default(), "155 4441114420563932")
 	if err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, 155, pair.Flat)
 	assert.Equal(t, "4441114420563932", pair.Card)
 
-	pair, err = flatAndCard("кв:155 4441114420563932")
+	pair, err = flatAndCard(rules.Default(), "кв:155 4441114420563932")
 	if err != nil {
 		t.Fatal(err)
 	}
 	assert.Equal(t, 155, pair.Flat)
 	assert.Equal(t, "4441114420563932", pair.Card)
 
-	pair, err = flatAndCard("155")
+	pair, err = flatAndCard(rules.Default(), "155")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,363 +40,345 @@ func TestFindFlatAndCard(t *testing.T) {
 	assert.Equal(t, "", pair.Card)
 }
 
-func TestProcessStatementNewSheet(t *testing.T) {
-	const confName = "conf.json"
-	const transactionsNumber = 10
-
-	confDir := t.TempDir()
-	confPath := filepath.Join(confDir, confName)
-
-	var tra []api.Transaction
-	for i := 0; i < transactionsNumber; i++ {
-		tra = append(tra, api.Transaction{
-			ID:      strconv.Itoa(i),
-			Comment: fmt.Sprintf("%d %d", i, i*50),
-			Amount:  i * 1000,
-		})
-	}
-
-	err := config.SetConfig(confPath, config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"})
+func writeSheet(t *testing.T, file *xlsx.File, records []store.Record) *xlsx.Sheet {
+	c := &config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"}
+	err := WriteSheet(context.Background(), file, records, c)
 	require.NoError(t, err)
-
-	file := xlsx.NewFile()
-	err = ProcessStatement(file, tra, confPath)
-	require.NoError(t, err)
-
 	assert.Equal(t, 1, len(file.Sheet))
+	return file.Sheet["2024-06-25"]
+}
 
-	for _, sheet := range file.Sheet {
-		// slice 1 for column names
-		require.Equal(t, transactionsNumber, len(sheet.Rows[1:]))
+func TestWriteSheetNewSheet(t *testing.T) {
+	const transactionsNumber = 10
 
-		rows := sheet.Rows[1:]
+	var records []store.Record
+	for i := transactionsNumber - 1; i >= 0; i-- {
+		records = append(records, store.Record{Flat: i, Transaction: api.Transaction{
+			ID:     strconv.Itoa(i),
+			Amount: (i + 1) * 1000,
+		}})
+	}
 
-		for i, row := range rows {
-			flatNum, err := strconv.Atoi(row.Cells[0].Value)
-			require.NoError(t, err)
-			amount, err := strconv.ParseFloat(row.Cells[1].Value, 64)
-			require.NoError(t, err)
-			trId, err := strconv.Atoi(row.Cells[2].Value)
-			require.NoError(t, err)
+	sheet := writeSheet(t, xlsx.NewFile(), records)
+	// slice 1 for column names
+	rows := sheet.Rows[1:]
+	require.Equal(t, transactionsNumber, len(rows))
 
-			assert.Equal(t, i, flatNum)
-			assert.Equal(t, float64(i*10), amount)
-			assert.Equal(t, i, trId)
-		}
-	}
-	c, err := config.GetConfig(confPath)
-	require.NoError(t, err)
+	for i, row := range rows {
+		flatNum, err := strconv.Atoi(row.Cells[flatIndex].Value)
+		require.NoError(t, err)
+		amount, err := strconv.ParseFloat(row.Cells[amountIndex].Value, 64)
+		require.NoError(t, err)
+		trId, err := strconv.Atoi(row.Cells[transactionIndex].Value)
+		require.NoError(t, err)
 
-	assert.Equal(t, 0, len(c.Exclusions))
+		assert.Equal(t, i, flatNum)
+		assert.Equal(t, float64((i+1)*10), amount)
+		assert.Equal(t, i, trId)
+	}
 }
 
-func TestProcessStatementExistingSheet(t *testing.T) {
-	const confName = "conf.json"
+func TestWriteSheetExistingSheet(t *testing.T) {
 	const transactionsNumber = 10
+	const overlapShift = 5
 
-	confDir := t.TempDir()
-	confPath := filepath.Join(confDir, confName)
-
-	var tra1 []api.Transaction
+	var records []store.Record
 	for i := 0; i < transactionsNumber; i++ {
-		tra1 = append(tra1, api.Transaction{
-			ID:      strconv.Itoa(i),
-			Comment: fmt.Sprintf("%d %d", i, i*50),
-			Amount:  i * 1000,
-		})
-	}
-	err := config.SetConfig(confPath, config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"})
-	if err != nil {
-		t.Fatal(err)
+		records = append(records, store.Record{Flat: i, Transaction: api.Transaction{ID: strconv.Itoa(i), Amount: (i + 1) * 1000}})
 	}
 	file := xlsx.NewFile()
-	err = ProcessStatement(file, tra1, confPath)
-	if err != nil {
-		t.Fatal(err)
-	}
+	writeSheet(t, file, records)
 
-	// Test
-	const overlapShift = 5
-	var tra2 []api.Transaction
-	for i := 5; i < transactionsNumber+overlapShift; i++ {
-		tra2 = append(tra2, api.Transaction{
-			ID:      strconv.Itoa(i * 2),
-			Comment: fmt.Sprintf("%d %d", i, i*50),
-			Amount:  i * 1000,
-		})
+	for i := overlapShift; i < transactionsNumber+overlapShift; i++ {
+		records = append(records, store.Record{Flat: i, Transaction: api.Transaction{ID: strconv.Itoa(i * 2), Amount: (i + 1) * 1000}})
 	}
+	// withdrawals are listed in the expenses sheet
+	records = append(records, store.Record{Transaction: api.Transaction{ID: "w", Amount: -5000}})
 
-	err = ProcessStatement(file, tra2, confPath)
-	if err != nil {
-		t.Fatal(err)
-	}
+	// the rows of the previous write are replaced
+	sheet := writeSheet(t, file, records)
+	require.Equal(t, transactionsNumber+overlapShift, len(sheet.Rows[1:]))
 
-	assert.Equal(t, 1, len(file.Sheet))
+	for i, row := range sheet.Rows[1:] {
+		flatNum, err := strconv.Atoi(row.Cells[flatIndex].Value)
+		require.NoError(t, err)
+		amount, err := strconv.ParseFloat(row.Cells[amountIndex].Value, 64)
+		require.NoError(t, err)
+
+		assert.Equal(t, i, flatNum)
 
-	for _, sheet := range file.Sheet {
-		// slice 1 for column names
-		require.Equal(t, transactionsNumber+overlapShift, len(sheet.Rows[1:]))
-
-		for i, row := range sheet.Rows[1:] {
-			flatNum, err := strconv.Atoi(row.Cells[0].Value)
-			require.NoError(t, err)
-			amount, err := strconv.ParseFloat(row.Cells[1].Value, 64)
-			require.NoError(t, err)
-
-			assert.Equal(t, i, flatNum)
-
-			if i < overlapShift {
-				assert.Equal(t, float64(i*10), amount)
-				assert.Equal(t, fmt.Sprintf("%d", i), row.Cells[2].Value)
-				continue
-			}
-			if i >= transactionsNumber {
-				assert.Equal(t, float64(i*10), amount)
-				assert.Equal(t, fmt.Sprintf("%d", i*2), row.Cells[2].Value)
-				continue
-			}
-
-			assert.Equal(t, fmt.Sprintf("%d,%d", i, i*2), row.Cells[2].Value)
-			assert.Equal(t, float64(i*10)*2, amount)
+		if i < overlapShift {
+			assert.Equal(t, float64((i+1)*10), amount)
+			assert.Equal(t, fmt.Sprintf("%d", i), row.Cells[transactionIndex].Value)
+			continue
 		}
+		if i >= transactionsNumber {
+			assert.Equal(t, float64((i+1)*10), amount)
+			assert.Equal(t, fmt.Sprintf("%d", i*2), row.Cells[transactionIndex].Value)
+			continue
+		}
+
+		assert.Equal(t, fmt.Sprintf("%d,%d", i, i*2), row.Cells[transactionIndex].Value)
+		assert.Equal(t, float64((i+1)*10)*2, amount)
 	}
-	_ = file.Save(`C:\Users\alexm\Documents\private\logs\test\file.xlsx`)
 }
 
-func TestProcessStatementInvalidComments(t *testing.T) {
-	const confName = "conf.json"
-
-	confDir := t.TempDir()
-	confPath := filepath.Join(confDir, confName)
-
-	var tra []api.Transaction
-	tra = append(tra, api.Transaction{
-		ID:      "10",
-		Comment: "24 4441166661984104",
-		Amount:  100_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "11",
-		Comment: "",
-		Amount:  110_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "12",
-		Comment: "144",
-		Amount:  120_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "14",
-		Comment: "",
-		Amount:  130_000,
-	})
-
-	err := config.SetConfig(confPath, config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"})
-	require.NoError(t, err)
+func TestWriteSheetUnknownFlat(t *testing.T) {
+	records := []store.Record{
+		{Flat: 24, Card: "4441166661984104", Transaction: api.Transaction{ID: "10", Amount: 100_000}},
+		{Transaction: api.Transaction{ID: "11", Amount: 110_000}},
+		{Flat: 144, Transaction: api.Transaction{ID: "12", Amount: 120_000}},
+		{Transaction: api.Transaction{ID: "14", Amount: 130_000}},
+	}
 
 	file := xlsx.NewFile()
-	err = ProcessStatement(file, tra, confPath)
-	require.NoError(t, err)
-	err = SortMainTable(file, confPath)
-	require.NoError(t, err)
+	rows := writeSheet(t, file, records).Rows[1:]
+	require.Equal(t, len(records)-1, len(rows))
+
+	assert.Equal(t, "0", rows[0].Cells[flatIndex].Value)
+	assert.Equal(t, "2400", rows[0].Cells[amountIndex].Value)
+	assert.Equal(t, "11,14", rows[0].Cells[transactionIndex].Value)
+
+	assert.Equal(t, "24", rows[1].Cells[flatIndex].Value)
+	assert.Equal(t, "1000", rows[1].Cells[amountIndex].Value)
+	assert.Equal(t, "10", rows[1].Cells[transactionIndex].Value)
+
+	assert.Equal(t, "144", rows[2].Cells[flatIndex].Value)
+	assert.Equal(t, "1200", rows[2].Cells[amountIndex].Value)
+	assert.Equal(t, "12", rows[2].Cells[transactionIndex].Value)
+
+	// resolving the unknown transactions removes the unknown flat row
+	records[1].Flat = 144
+	records[3].Flat = 24
+	rows = writeSheet(t, file, records).Rows[1:]
+	require.Equal(t, 2, len(rows))
+
+	assert.Equal(t, "24", rows[0].Cells[flatIndex].Value)
+	assert.Equal(t, "2300", rows[0].Cells[amountIndex].Value)
+	assert.Equal(t, "10,14", rows[0].Cells[transactionIndex].Value)
+
+	assert.Equal(t, "144", rows[1].Cells[flatIndex].Value)
+	assert.Equal(t, "2300", rows[1].Cells[amountIndex].Value)
+	assert.Equal(t, "11,12", rows[1].Cells[transactionIndex].Value)
+}
 
-	assert.Equal(t, 1, len(file.Sheet))
+func TestWriteSheetKopecks(t *testing.T) {
+	records := []store.Record{
+		{Flat: 5, Transaction: api.Transaction{ID: "1", Amount: 15050, CurrencyCode: money.UAH}},
+		{Flat: 5, Transaction: api.Transaction{ID: "2", Amount: 15050, CurrencyCode: money.UAH}},
+		{Transaction: api.Transaction{ID: "3", Amount: 99, CurrencyCode: money.UAH}},
+	}
 
-	for _, sheet := range file.Sheet {
-		rows := sheet.Rows[1:]
-		require.Equal(t, len(tra)-1, len(rows))
+	file := xlsx.NewFile()
+	writeSheet(t, file, records)
 
-		firstRowCells := rows[0].Cells
-		assert.Equal(t, "0", firstRowCells[flatIndex].Value)
-		assert.Equal(t, "2400", firstRowCells[amountIndex].Value)
-		assert.Equal(t, "11,14", firstRowCells[transactionIndex].Value)
+	xlsxPath := filepath.Join(t.TempDir(), "kopecks.xlsx")
+	require.NoError(t, file.Save(xlsxPath))
+	file, err := xlsx.OpenFile(xlsxPath)
+	require.NoError(t, err)
 
-		secondRowCells := rows[1].Cells
-		assert.Equal(t, "24", secondRowCells[flatIndex].Value)
-		assert.Equal(t, "1000", secondRowCells[amountIndex].Value)
-		assert.Equal(t, "10", secondRowCells[transactionIndex].Value)
+	rows := file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 2, len(rows))
 
-		thirdRowCells := rows[2].Cells
-		assert.Equal(t, "144", thirdRowCells[flatIndex].Value)
-		assert.Equal(t, "1200", thirdRowCells[amountIndex].Value)
-		assert.Equal(t, "12", thirdRowCells[transactionIndex].Value)
-	}
+	amount, err := getAmount(rows[0].Cells[amountIndex], 0)
+	require.NoError(t, err)
+	assert.Equal(t, money.New(99, money.UAH), amount)
 
-	c, err := config.GetConfig(confPath)
+	amount, err = getAmount(rows[1].Cells[amountIndex], 0)
 	require.NoError(t, err)
+	assert.Equal(t, money.New(30100, money.UAH), amount)
+	assert.Equal(t, currencyFormats[money.UAH], rows[1].Cells[amountIndex].NumFmt)
+}
 
-	assert.Equal(t, 2, len(c.Exclusions))
-	_ = file.Save(`C:\Users\alexm\Documents\private\logs\test\text.xlsx`)
+func TestWriteSheetCurrencyMismatch(t *testing.T) {
+	c := &config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"}
+	err := WriteSheet(context.Background(), xlsx.NewFile(), []store.Record{
+		{Flat: 5, Transaction: api.Transaction{ID: "1", Amount: 15050, CurrencyCode: money.UAH}},
+		{Flat: 5, Transaction: api.Transaction{ID: "2", Amount: 1000, CurrencyCode: money.USD}},
+	}, c)
+	require.ErrorIs(t, err, money.ErrCurrencyMismatch)
+	assert.Contains(t, err.Error(), "flat 5")
 }
 
-func TestExclusions(t *testing.T) {
-	// prepare
-
-	const confName = "conf.json"
-
-	confDir := t.TempDir()
-	confPath := filepath.Join(confDir, confName)
-
-	var tra []api.Transaction
-	tra = append(tra, api.Transaction{
-		ID:      "10",
-		Comment: "24 4441166661984104",
-		Amount:  100_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "11",
-		Comment: "",
-		Amount:  110_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "12",
-		Comment: "144",
-		Amount:  120_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "14",
-		Comment: "",
-		Amount:  130_000,
-	})
-
-	err := config.SetConfig(confPath, config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"})
+func TestWriteSheetLegacyAmounts(t *testing.T) {
+	// a sheet written by the integer hryvnia version
+	file := xlsx.NewFile()
+	sheet, err := getSheet(context.Background(), file, "2024-06-25")
 	require.NoError(t, err)
+	for _, r := range []struct {
+		flat         int
+		amount       int
+		transactions string
+	}{{5, 301, "1,2"}, {7, 100, "3"}} {
+		row := sheet.AddRow()
+		row.AddCell().SetInt(r.flat)
+		row.AddCell().SetInt(r.amount)
+		row.AddCell().Value = r.transactions
+	}
 
-	file := xlsx.NewFile()
-	err = ProcessStatement(file, tra, confPath)
+	// the stored transactions restore the dropped kopecks
+	rows := writeSheet(t, file, []store.Record{
+		{Flat: 5, Transaction: api.Transaction{ID: "1", Amount: 15050}},
+		{Flat: 5, Transaction: api.Transaction{ID: "2", Amount: 15050}},
+		{Flat: 7, Transaction: api.Transaction{ID: "3", Amount: 10001}},
+	}).Rows[1:]
+	require.Equal(t, 2, len(rows))
+
+	amount, err := getAmount(rows[0].Cells[amountIndex], 0)
 	require.NoError(t, err)
-	err = SortMainTable(file, confPath)
+	assert.Equal(t, money.New(30100, money.UAH), amount)
+	assert.Equal(t, "1,2", rows[0].Cells[transactionIndex].Value)
+
+	amount, err = getAmount(rows[1].Cells[amountIndex], 0)
 	require.NoError(t, err)
+	assert.Equal(t, money.New(10001, money.UAH), amount)
+	assert.Equal(t, currencyFormats[money.UAH], rows[1].Cells[amountIndex].NumFmt)
+}
 
-	// test
-
-	err = config.SetConfig(
-		confPath,
-		config.Config{
-			XToken:   "",
-			JarName:  "",
-			JarStart: "2024-06-25 11:00:00 +0300 EEST",
-			Exclusions: []config.Exclusion{{
-				Card:          "",
-				Flat:          144,
-				Comment:       "",
-				TransactionID: "11",
-				Amount:        0,
-			}},
+func TestResolveRegistry(t *testing.T) {
+	c := &config.Config{
+		JarStart: "2024-06-25 11:00:00 +0300 EEST",
+		Flats: []config.Flat{
+			{Number: 12, Cards: []string{"444111******3932"}},
+			{Number: 45, IBANs: []string{"UA213223130000026007233566001"}},
 		},
-	)
+		Exclusions: []config.Exclusion{{TransactionID: "6", Flat: 45}, {TransactionID: "7", Card: "Unknown"}},
+	}
+	engine, err := rules.FromConfig(c)
 	require.NoError(t, err)
 
-	err = ProcessStatement(file, tra, confPath)
-	require.NoError(t, err)
-	err = SortMainTable(file, confPath)
-	require.NoError(t, err)
+	learned := func(tr api.Transaction) (*FlatAndCard, store.Source, bool) {
+		if tr.CounterName == "Petrenko" {
+			return &FlatAndCard{Flat: 7}, store.SourceLearned, true
+		}
+		return nil, "", false
+	}
 
-	for _, sheet := range file.Sheet {
-		rows := sheet.Rows[1:]
-		require.Equal(t, len(tra)-1, len(rows))
+	for _, tc := range []struct {
+		transaction api.Transaction
+		flat        int
+		source      store.Source
+	}{
+		{api.Transaction{ID: "1", Comment: "кв 12"}, 12, store.SourceComment},
+		// 150 is not a registered flat
+		{api.Transaction{ID: "2", Comment: "150"}, 0, store.SourceUnknown},
+		{api.Transaction{ID: "3", Comment: "4441114420563932"}, 12, store.SourceRegistry},
+		{api.Transaction{ID: "4", CounterIban: "UA213223130000026007233566001"}, 45, store.SourceRegistry},
+		{api.Transaction{ID: "5", Comment: "дякую"}, 0, store.SourceUnknown},
+		{api.Transaction{ID: "6", Comment: "дякую"}, 45, store.SourceExclusion},
+		{api.Transaction{ID: "7", CounterName: "Petrenko"}, 7, store.SourceLearned},
+		{api.Transaction{ID: "8", CounterName: "Petrenko", Comment: "4441114420563932"}, 12, store.SourceRegistry},
+	} {
+		pair, source := Resolve(c, engine, tc.transaction, learned)
+		assert.Equal(t, tc.flat, pair.Flat, tc.transaction.ID)
+		assert.Equal(t, tc.source, source, tc.transaction.ID)
+	}
 
-		firstRowCells := rows[0].Cells
-		assert.Equal(t, "0", firstRowCells[flatIndex].Value)
-		assert.Equal(t, "1300", firstRowCells[amountIndex].Value)
-		assert.Equal(t, "14", firstRowCells[transactionIndex].Value)
+	// unresolved transactions keep the pair of their exclusion
+	pair, source := Resolve(c, engine, api.Transaction{ID: "7"})
+	assert.Equal(t, store.SourceUnknown, source)
+	assert.Equal(t, "Unknown", pair.Card)
+}
 
-		secondRowCells := rows[1].Cells
-		assert.Equal(t, "24", secondRowCells[flatIndex].Value)
-		assert.Equal(t, "1000", secondRowCells[amountIndex].Value)
-		assert.Equal(t, "10", secondRowCells[transactionIndex].Value)
+func TestWriteBalanceSheet(t *testing.T) {
+	c := &config.Config{
+		JarStart: "2024-06-25 11:00:00 +0300 EEST",
+		Flats: []config.Flat{
+			{Number: 12, Owner: "Petrenko", ExpectedMonthly: money.New(20000, money.UAH)},
+			{Number: 45, ExpectedMonthly: money.New(20000, money.UAH)},
+		},
+	}
 
-		thirdRowCells := rows[2].Cells
-		assert.Equal(t, "144", thirdRowCells[flatIndex].Value)
-		assert.Equal(t, "2300", thirdRowCells[amountIndex].Value)
-		assert.Equal(t, "12,11", thirdRowCells[transactionIndex].Value)
+	records := []store.Record{
+		{Flat: 12, Transaction: api.Transaction{ID: "1", Amount: 30050}},
+		{Flat: 12, Transaction: api.Transaction{ID: "2", Amount: 10000}},
+		{Flat: 12, Transaction: api.Transaction{ID: "3", Amount: -5000}},
 	}
 
-	c, err := config.GetConfig(confPath)
+	file := xlsx.NewFile()
+	now := time.Date(2024, 8, 1, 0, 0, 0, 0, time.UTC)
+	err := WriteBalanceSheet(context.Background(), file, records, c, now)
 	require.NoError(t, err)
 
-	assert.Equal(t, 2, len(c.Exclusions))
-	_ = file.Save(`C:\Users\alexm\Documents\private\logs\test\text.xlsx`)
+	sheet := file.Sheet["2024-06-25 balance"]
+	require.NotNil(t, sheet)
+	require.Equal(t, 3, len(sheet.Rows))
+
+	cells := sheet.Rows[1].Cells
+	assert.Equal(t, "12", cells[0].Value)
+	assert.Equal(t, "Petrenko", cells[3].Value)
+	assert.Equal(t, "400.5", cells[4].Value)
+	assert.Equal(t, "600", cells[5].Value)
+	assert.Equal(t, "-199.5", cells[6].Value)
+
+	cells = sheet.Rows[2].Cells
+	assert.Equal(t, "45", cells[0].Value)
+	assert.Equal(t, "0", cells[4].Value)
+	assert.Equal(t, "-600", cells[6].Value)
 }
 
-func TestExclusions1(t *testing.T) {
-	// prepare
-
-	const confName = "conf.json"
-
-	confDir := t.TempDir()
-	confPath := filepath.Join(confDir, confName)
-
-	var tra []api.Transaction
-	tra = append(tra, api.Transaction{
-		ID:      "10",
-		Comment: "24 4441166661984104",
-		Amount:  100_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "11",
-		Comment: "",
-		Amount:  110_000,
-	})
-	tra = append(tra, api.Transaction{
-		ID:      "12",
-		Comment: "144",
-		Amount:  120_000,
-	})
-	err := config.SetConfig(confPath, config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"})
-	require.NoError(t, err)
+func TestWriteExpensesAndSummarySheets(t *testing.T) {
+	c := &config.Config{JarStart: "2024-06-25 11:00:00 +0300 EEST"}
+
+	at := func(month time.Month, day int) int64 {
+		return time.Date(2024, month, day, 12, 0, 0, 0, time.UTC).Unix()
+	}
+	records := []store.Record{
+		{Flat: 12, Transaction: api.Transaction{ID: "1", Time: at(6, 26), Amount: 50000}},
+		{Transaction: api.Transaction{ID: "2", Time: at(6, 28), Amount: -30050, MCC: 5541, Description: "WOG"}},
+		{Flat: 7, Transaction: api.Transaction{ID: "3", Time: at(8, 2), Amount: 20000}},
+		{Transaction: api.Transaction{ID: "4", Time: at(8, 3), Amount: -10000, Comment: "ТО генератора"}},
+	}
+
 	file := xlsx.NewFile()
-	err = ProcessStatement(file, tra, confPath)
+	err := WriteExpensesSheet(context.Background(), file, records, c)
 	require.NoError(t, err)
-	err = SortMainTable(file, confPath)
+	now := time.Date(2024, 8, 10, 0, 0, 0, 0, time.UTC)
+	err = WriteSummarySheet(context.Background(), file, records, c, now)
 	require.NoError(t, err)
 
-	// test
-
-	err = config.SetConfig(
-		confPath,
-		config.Config{
-			XToken:   "",
-			JarName:  "",
-			JarStart: "2024-06-25 11:00:00 +0300 EEST",
-			Exclusions: []config.Exclusion{{
-				Card:          "",
-				Flat:          144,
-				Comment:       "",
-				TransactionID: "11",
-				Amount:        0,
-			}},
-		},
-	)
-	require.NoError(t, err)
-
-	for i := 0; i < 10; i++ {
-		err = ProcessStatement(file, tra, confPath)
-		require.NoError(t, err)
-		err = SortMainTable(file, confPath)
-		require.NoError(t, err)
-		err = CleanZeroAmountValues(file, confPath)
-		require.NoError(t, err)
-
-		for _, sheet := range file.Sheet {
-			rows := sheet.Rows[1:]
-			require.Equal(t, len(tra)-1, len(rows))
-
-			secondRowCells := rows[0].Cells
-			assert.Equal(t, "24", secondRowCells[flatIndex].Value)
-			assert.Equal(t, "1000", secondRowCells[amountIndex].Value)
-			assert.Equal(t, "10", secondRowCells[transactionIndex].Value)
-
-			thirdRowCells := rows[1].Cells
-			assert.Equal(t, "144", thirdRowCells[flatIndex].Value)
-			assert.Equal(t, "2300", thirdRowCells[amountIndex].Value)
-			assert.Equal(t, "12,11", thirdRowCells[transactionIndex].Value)
+	sheet := file.Sheet["2024-06-25 expenses"]
+	require.NotNil(t, sheet)
+	require.Equal(t, 3, len(sheet.Rows))
+	cells := sheet.Rows[1].Cells
+	assert.Equal(t, "2024-06-28 15:00:00", cells[0].Value)
+	assert.Equal(t, "300.5", cells[1].Value)
+	assert.Equal(t, "Fuel", cells[2].Value)
+	assert.Equal(t, "WOG", cells[3].Value)
+	assert.Equal(t, "2", cells[6].Value)
+	assert.Equal(t, "Generator service", sheet.Rows[2].Cells[2].Value)
+
+	sheet = file.Sheet["2024-06-25 summary"]
+	require.NotNil(t, sheet)
+	require.Equal(t, 4, len(sheet.Rows))
+	for i, expected := range [][]string{
+		{"2024-06", "500", "300.5", "199.5"},
+		{"2024-07", "0", "0", "199.5"},
+		{"2024-08", "200", "100", "299.5"},
+	} {
+		cells := sheet.Rows[i+1].Cells
+		for j, value := range expected {
+			assert.Equal(t, value, cells[j].Value, "row %d column %d", i+1, j)
 		}
 	}
+}
 
-	c, err := config.GetConfig(confPath)
-	require.NoError(t, err)
+const (
+	flatIndex        = 0
+	amountIndex      = 1
+	transactionIndex = 2
+)
 
-	assert.Equal(t, 1, len(c.Exclusions))
-	_ = file.Save(`C:\Users\alexm\Documents\private\logs\test\text.xlsx`)
+// getAmount reads an amount cell, currency is used when the format does not tell it.
+func getAmount(cell *xlsx.Cell, currency int) (money.Money, error) {
+	f, err := cell.Float()
+	if err != nil {
+		return money.Money{}, err
+	}
+	for c, format := range currencyFormats {
+		if cell.NumFmt == format {
+			currency = c
+		}
+	}
+	return money.FromFloat(f, currency), nil
 }
@@ -0,0 +1,56 @@
+package exel
+
+import (
+	"context"
+	"diesgen/config"
+	"diesgen/store"
+	"fmt"
+	"github.com/tealeg/xlsx"
+	"strings"
+	"time"
+)
+
+// WriteReconciliationSheet lists every change of the difference between the jar balance
+// and the stored transactions.
+func WriteReconciliationSheet(ctx context.Context, file *xlsx.File, reconciliations []store.Reconciliation, c *config.Config) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(reconciliations) == 0 {
+		return nil
+	}
+
+	start, err := c.JarStartTime()
+	if err != nil {
+		return err
+	}
+	sname, err := c.SheetName()
+	if err != nil {
+		return err
+	}
+
+	sheet, err := resetSheet(file, sname+config.ReconciliationSheetSuffix)
+	if err != nil {
+		return err
+	}
+
+	header := sheet.AddRow()
+	for _, column := range []string{"Time", "Jar balance", "Computed", "Discrepancy", "Gaps"} {
+		header.AddCell().Value = column
+	}
+
+	for _, r := range reconciliations {
+		gaps := make([]string, 0, len(r.Gaps))
+		for _, g := range r.Gaps {
+			gaps = append(gaps, fmt.Sprintf("%s missing between %s and %s", g.Amount, g.After, g.Before))
+		}
+
+		row := sheet.AddRow()
+		row.AddCell().Value = r.Time.In(start.Location()).Format(time.DateTime)
+		setAmount(row.AddCell(), r.JarBalance)
+		setAmount(row.AddCell(), r.Computed)
+		setAmount(row.AddCell(), r.Discrepancy())
+		row.AddCell().Value = strings.Join(gaps, "; ")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package exel
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"diesgen/safefile"
+	"errors"
+	"github.com/tealeg/xlsx"
+	"maps"
+	"os"
+	"slices"
+)
+
+// Save replaces the workbook at path atomically keeping backups of the previous versions,
+// an open or crashed writer never leaves a truncated file behind. The workbook is left
+// unchanged when ctx is done before it is replaced or when its content would not change.
+func Save(ctx context.Context, file *xlsx.File, path string) error {
+	parts, err := file.MarshallParts()
+	if err != nil {
+		return err
+	}
+	unchanged, err := sameParts(path, parts)
+	if err != nil || unchanged {
+		return err
+	}
+
+	data, err := zipParts(parts)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return safefile.Save(path, data, 0644)
+}
+
+// sameParts reports whether the workbook at path has the parts. The saved bytes can not be compared
+// since tealeg renumbers styles on every open, the current workbook is marshalled again instead.
+func sameParts(path string, parts map[string]string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+		return false, err
+	}
+	current, err := xlsx.OpenBinary(data)
+	if err != nil {
+		// an unreadable workbook is replaced
+		return false, nil
+	}
+	currentParts, err := current.MarshallParts()
+	if err != nil {
+		return false, nil
+	}
+	return maps.Equal(parts, currentParts), nil
+}
+
+// zipParts zips the parts ordered by name, so equal workbooks are equal files.
+func zipParts(parts map[string]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	names := make([]string, 0, len(parts))
+	for name := range parts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write([]byte(parts[name]))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
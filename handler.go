@@ -1,59 +1,525 @@
 package main
 
 import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/safefile"
+	"diesgen/schedule"
 	"diesgen/service"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows/svc"
+	"io/fs"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
 	"time"
 )
 
+// Command is a platform neutral control request delivered to DiesGenService.Run
+// by the windows service handler or the unix signal runner.
+type Command int
+
+const (
+	CommandStop Command = iota
+	CommandPause
+	CommandContinue
+	CommandSync
+)
+
+// State is a platform neutral service state reported by DiesGenService.Run.
+type State int
+
+const (
+	StateStartPending State = iota
+	StateRunning
+	StatePaused
+	StateStopPending
+	StateStopped
+)
+
+func (s State) String() string {
+	switch s {
+	case StateStartPending:
+		return "StartPending"
+	case StateRunning:
+		return "Running"
+	case StatePaused:
+		return "Paused"
+	case StateStopPending:
+		return "StopPending"
+	case StateStopped:
+		return "Stopped"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	// configCheckInterval is how often the config file is checked for changes.
+	configCheckInterval = 5 * time.Second
+	// maxBackoff bounds the delay of the syncs of a tenant while monobank rate limits its token.
+	maxBackoff = 30 * time.Minute
+	// stopTimeout is how long a stop waits for the canceled syncs, together with the server
+	// shutdowns it stays within TimeoutStopSec of the systemd unit.
+	stopTimeout = 15 * time.Second
+)
+
+// errNotRunning is returned when the service is asked to pause or resume while starting or stopping.
+var errNotRunning = errors.New("service is not running")
+
 type DiesGenService struct {
-	ConfigPath string
-	XlsxPath   string
+	// WebhookAddr is the listen address of the webhook receiver, it is not started when empty
+	WebhookAddr string
+	// AdminAddr is the listen address of the admin api and /metrics, it is not started when empty
+	AdminAddr string
+	// PauseFile keeps the service paused across restarts when it was paused through the admin api
+	PauseFile string
+	// CatchUp syncs the tenants on resume whose ticks were skipped while paused
+	CatchUp bool
+
+	tenants []*tenant
+
+	mu     sync.Mutex
+	state  State
+	report func(State)
 }
 
-func NewDiesGenService(configPath string, xlsxPath string) *DiesGenService {
-	return &DiesGenService{ConfigPath: configPath, XlsxPath: xlsxPath}
+// tenant is a building served by the service. Every tenant is synced by its own loop so
+// waiting for the rate limits of one token does not delay the others.
+type tenant struct {
+	service.Options
+	config *config.Watcher
+	log    *log.Entry
+	events chan api.WebHookEvent
+	// syncs requests an immediate sync, one requested while the tenant is syncing is run afterwards
+	syncs chan struct{}
+	// resumed tells the loop the service was resumed and whether to catch up
+	resumed chan bool
+	paused  func() bool
+
+	// the fields up to mu are used by the loop of the tenant only
+	lastEvent time.Time
+	lastPoll  time.Time
+	// nextTick is the time of the next scheduled sync, timer fires then
+	nextTick time.Time
+	timer    *time.Timer
+	schedule *schedule.Schedule
+	// backoff delays the scheduled syncs while monobank rate limits the token
+	backoff time.Duration
+	// skipped is set when a sync was skipped while paused
+	skipped bool
+
+	mu sync.Mutex
+	// webhook is the path of the webhookUrl of the config
+	webhook string
+	seen    map[string]bool
+	status  tenantStatus
 }
 
-func (m *DiesGenService) Execute(_ []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (svcSpecificEC bool, errno uint32) {
-	changes <- svc.Status{State: svc.StartPending}
-	log.Info("StartPending")
+// NewDiesGenService returns the service syncing every instance of the options.
+func NewDiesGenService(instances ...service.Options) *DiesGenService {
+	m := &DiesGenService{}
+	for _, o := range instances {
+		m.tenants = append(m.tenants, &tenant{
+			Options:  o,
+			config:   config.NewWatcher(o.ConfigPath, validateConfig),
+			log:      o.Logger(),
+			events:   make(chan api.WebHookEvent, webhookQueue),
+			syncs:    make(chan struct{}, 1),
+			resumed:  make(chan bool, 1),
+			paused:   m.paused,
+			schedule: schedule.Default(),
+			seen:     make(map[string]bool),
+			status:   tenantStatus{Name: o.Tenant},
+		})
+	}
+	return m
+}
 
-	processTick := time.Tick(60 * time.Second)
+// Run executes the polling loops until CommandStop is received or commands is closed.
+// Every state change is passed to report.
+func (m *DiesGenService) Run(commands <-chan Command, report func(State)) {
+	m.report = report
+	m.setState(StateStartPending)
 
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
-	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-	log.Info("Running")
+	receiver := m.startWebhook()
+	var admin *http.Server
+	if m.AdminAddr != "" {
+		admin = serve("admin", m.AdminAddr, m.adminHandler())
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var wg sync.WaitGroup
 
-	service.Process(m.ConfigPath, m.XlsxPath)
+	m.setState(StateRunning)
+	if m.pausedBefore() {
+		log.Infof("paused through the admin api before the restart, remove %s or resume to sync", m.PauseFile)
+		m.setState(StatePaused)
+	}
+
+	for _, t := range m.tenants {
+		wg.Add(1)
+		go func(t *tenant) {
+			defer wg.Done()
+			t.run(ctx)
+		}(t)
+	}
 
 loop:
 	for {
+		c, ok := <-commands
+		if !ok {
+			break
+		}
+		switch c {
+		case CommandStop:
+			log.Info("Stop received")
+			break loop
+		case CommandPause:
+			log.Info("Pause received")
+			if err := m.pause(false); err != nil {
+				log.Error(err)
+			}
+		case CommandContinue:
+			log.Info("Continue received")
+			if err := m.resume(); err != nil {
+				log.Error(err)
+			}
+		case CommandSync:
+			log.Info("Sync received")
+			if m.paused() {
+				log.Info("sync skipped, the service is paused")
+				continue
+			}
+			m.sync("")
+		default:
+			log.Errorf("unexpected command: %v", c)
+		}
+	}
+
+	m.setState(StateStopPending)
+	// running syncs abandon their requests
+	cancel()
+	shutdown(receiver)
+	shutdown(admin)
+	wait(&wg, stopTimeout)
+	m.setState(StateStopped)
+}
+
+// wait waits for wg at most timeout.
+func wait(wg *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("syncs did not stop within %s", timeout)
+	}
+}
+
+// setState records the state and passes it to the runner.
+func (m *DiesGenService) setState(s State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setStateLocked(s)
+}
+
+func (m *DiesGenService) setStateLocked(s State) {
+	m.state = s
+	m.report(s)
+	log.Info(s)
+}
+
+func (m *DiesGenService) paused() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state == StatePaused
+}
+
+// pause stops starting syncs until resume, running ones are finished. A pause requested
+// through the admin api is persisted to PauseFile to survive restarts.
+func (m *DiesGenService) pause(persist bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state != StateRunning && m.state != StatePaused {
+		return fmt.Errorf("pause: %w", errNotRunning)
+	}
+
+	if persist && m.PauseFile != "" {
+		err := safefile.WriteFile(m.PauseFile, []byte(fmt.Sprintf("paused at %s\n", time.Now().Format(time.RFC3339))), 0o644)
+		if err != nil {
+			return fmt.Errorf("pause: %w", err)
+		}
+	}
+	if m.state != StatePaused {
+		m.setStateLocked(StatePaused)
+	}
+	return nil
+}
+
+// resume starts syncing again and forgets a persisted pause.
+func (m *DiesGenService) resume() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state != StateRunning && m.state != StatePaused {
+		return fmt.Errorf("resume: %w", errNotRunning)
+	}
+
+	if m.PauseFile != "" {
+		err := os.Remove(m.PauseFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("resume: %w", err)
+		}
+	}
+	if m.state != StatePaused {
+		return nil
+	}
+	m.setStateLocked(StateRunning)
+	for _, t := range m.tenants {
 		select {
-		case <-processTick:
-			service.Process(m.ConfigPath, m.XlsxPath)
-		case c := <-r:
-			switch c.Cmd {
-			case svc.Stop, svc.Shutdown:
-				log.Info("Stop or Shutdown received")
-				break loop
-			case svc.Pause:
-				log.Info("Pause received")
-				changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
-			case svc.Continue:
-				log.Info("Continue received")
-				changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
-			default:
-				log.Error("unexpected control request: %v", c)
+		case t.resumed <- m.CatchUp:
+		default:
+		}
+	}
+	return nil
+}
+
+// pausedBefore reports whether the PauseFile of a pause requested through the admin api exists.
+func (m *DiesGenService) pausedBefore() bool {
+	if m.PauseFile == "" {
+		return false
+	}
+	_, err := os.Stat(m.PauseFile)
+	return err == nil
+}
+
+// sync requests an immediate sync of the tenant with the name, of every tenant when it is empty.
+// It reports whether a tenant was found.
+func (m *DiesGenService) sync(name string) bool {
+	found := false
+	for _, t := range m.tenants {
+		if name != "" && t.Tenant != name {
+			continue
+		}
+		found = true
+		select {
+		case t.syncs <- struct{}{}:
+		default:
+		}
+	}
+	return found
+}
+
+// run syncs the tenant on start, on the times of its schedule, on request and when its config
+// changes the attribution, and adds pushed transactions until ctx is done. Nothing is synced
+// while the service is paused, only requested syncs during the quiet hours of the schedule.
+func (t *tenant) run(ctx context.Context) {
+	configTicker := time.NewTicker(configCheckInterval)
+	defer configTicker.Stop()
+
+	// the schedule of the config is loaded before the timer is planned
+	t.checkConfig()
+	t.timer = time.NewTimer(time.Hour)
+	defer t.timer.Stop()
+	// the first sync is delayed by the jitter too, so instances started together do not poll together
+	now := time.Now()
+	t.reset(now, now.Add(t.schedule.Jitter()))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.timer.C:
+			if t.pollDue(now) && !t.quiet() {
+				t.tryProcess(ctx)
+			}
+			t.plan(time.Now())
+		case <-configTicker.C:
+			if t.checkConfig() && !t.quiet() {
+				t.log.Info("rules or exclusions changed, syncing")
+				t.tryProcess(ctx)
 			}
+		case <-t.syncs:
+			t.tryProcess(ctx)
+		case catchUp := <-t.resumed:
+			if catchUp && t.skipped && !t.quiet() {
+				t.log.Info("catching up on the syncs skipped while paused")
+				t.process(ctx)
+			} else {
+				t.scheduleNext()
+			}
+		case e := <-t.events:
+			if t.paused() {
+				t.log.Infof("service paused, pushed transaction %s is left to polling", e.Data.StatementItem.ID)
+				t.skipped = true
+				continue
+			}
+			t.push(ctx, e)
+		}
+	}
+}
+
+// plan sets the timer to the next time of the schedule after now delayed by the jitter
+// and the rate limit backoff.
+func (t *tenant) plan(now time.Time) {
+	next := t.schedule.Next(now).Add(t.schedule.Jitter())
+	if at := now.Add(t.backoff); next.Before(at) {
+		next = at
+	}
+	t.reset(now, next)
+}
+
+// reset sets the timer to fire on the next tick.
+func (t *tenant) reset(now time.Time, next time.Time) {
+	t.nextTick = next
+	if !t.timer.Stop() {
+		select {
+		case <-t.timer.C:
+		default:
 		}
 	}
+	t.timer.Reset(next.Sub(now))
+	t.scheduleNext()
+}
+
+// quiet reports whether the schedule has quiet hours now, syncs other than requested ones are skipped then.
+func (t *tenant) quiet() bool {
+	if !t.schedule.Quiet(time.Now()) {
+		return false
+	}
+	t.log.Debug("quiet hours, sync skipped")
+	return true
+}
+
+// tryProcess syncs the tenant unless the service is paused.
+func (t *tenant) tryProcess(ctx context.Context) {
+	if t.paused() {
+		t.skipped = true
+		t.scheduleNext()
+		return
+	}
+	t.process(ctx)
+}
+
+func (t *tenant) process(ctx context.Context) {
+	o, ok := t.options()
+	if !ok {
+		return
+	}
+
+	t.lastPoll = time.Now()
+	t.skipped = false
+	t.setRunning()
+	// syncs failing after the bank answered still prove the token
+	o.Authorized = t.authorized
+	err := service.Process(ctx, o)
+	t.finished(err)
+	if errors.Is(err, api.ErrRateLimited) {
+		t.backoff = min(max(2*t.backoff, api.RequestInterval), maxBackoff)
+	} else {
+		t.backoff = 0
+	}
+	t.scheduleNext()
+	// exclusions added by the sync itself are not a reason to sync again
+	t.checkConfig()
+
+	switch {
+	case err == nil:
+	case errors.Is(err, context.Canceled):
+		t.log.Warnf("sync canceled: %v", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		t.log.Errorf("sync timed out, retrying on the next tick: %v", err)
+	case errors.Is(err, api.ErrUnauthorized):
+		t.log.Errorf("monobank rejected the token, check xToken in %s: %v", t.ConfigPath, err)
+	case errors.Is(err, api.ErrRateLimited):
+		t.log.Warnf("monobank rate limit reached, delaying the syncs by %s: %v", t.backoff, err)
+	default:
+		t.log.Error(err)
+	}
+}
+
+// push adds a transaction pushed by the webhook.
+func (t *tenant) push(ctx context.Context, e api.WebHookEvent) {
+	t.lastEvent = time.Now()
+	t.scheduleNext()
+	o, ok := t.options()
+	if !ok {
+		return
+	}
+
+	_, err := service.Push(ctx, o, e.Data.Account, e.Data.StatementItem)
+	t.checkConfig()
+
+	switch {
+	case err == nil:
+	case errors.Is(err, service.ErrUnknownJar):
+		t.log.Infof("pushed transaction ignored: %v", err)
+	case errors.Is(err, service.ErrUnverifiable):
+		t.log.Warnf("pushed transaction ignored: %v", err)
+	default:
+		t.log.Errorf("pushed transaction %s, left to polling: %v", e.Data.StatementItem.ID, err)
+	}
+}
+
+// options returns the options to sync with, the last good config is used while the config is invalid.
+func (t *tenant) options() (service.Options, bool) {
+	t.checkConfig()
+	path, err := t.config.Path()
+	if err != nil {
+		t.log.Errorf("sync skipped: %v", err)
+		return service.Options{}, false
+	}
+
+	o := t.Options
+	if path != o.ConfigPath {
+		t.log.Warnf("syncing with the last good config %s", path)
+		o.ConfigPath = path
+	}
+	return o, true
+}
 
-	changes <- svc.Status{State: svc.StopPending}
-	log.Info("StopPending")
-	changes <- svc.Status{State: svc.Stopped}
-	log.Info("Stopped")
+// setSchedule replaces the schedule with the one of the reloaded config and replans the next
+// sync when it changed. Configs with an invalid schedule are rejected by validateConfig.
+func (t *tenant) setSchedule(prev *config.Config, next *config.Config) {
+	if prev != nil && reflect.DeepEqual(prev.Schedule, next.Schedule) {
+		return
+	}
+	s, err := schedule.FromConfig(next)
+	if err != nil {
+		t.log.Errorf("schedule kept: %v", err)
+		return
+	}
+	t.schedule = s
+	if t.timer != nil {
+		t.plan(time.Now())
+	}
+}
 
-	return
+// checkConfig reloads the config when the file changed and reports whether
+// a valid new version attributes payments differently.
+func (t *tenant) checkConfig() bool {
+	prev, next, err := t.config.Check()
+	if err != nil {
+		t.log.Error(err)
+	}
+	if next != nil {
+		t.setWebhook(next.WebhookURL)
+		t.setConfigError(nil)
+		t.setSchedule(prev, next)
+	} else if err != nil {
+		t.setConfigError(err)
+	}
+	if prev == nil || next == nil {
+		return false
+	}
+	t.log.Infof("config %s reloaded", t.ConfigPath)
+	service.ForgetCampaigns(t.Tenant, prev, next)
+	return config.AttributionChanged(prev, next)
 }
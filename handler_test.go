@@ -0,0 +1,164 @@
+package main
+
+import (
+	"diesgen/api"
+	"diesgen/api/fake"
+	"diesgen/config"
+	"diesgen/service"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	// waitFor bounds the wait for a sync of the service
+	waitFor = 5 * time.Second
+	// quietFor is how long the service must not sync
+	quietFor = 300 * time.Millisecond
+	tick     = 10 * time.Millisecond
+)
+
+// newTestTenant returns a bank serving the jar of the tenant. The token is unique to every call
+// so the rate limits shared by the syncs let the first sync of every test run at once.
+func newTestTenant(t *testing.T) (*fake.Server, service.Options) {
+	token := fmt.Sprintf("%s-%d", t.Name(), time.Now().UnixNano())
+	server := fake.NewServer(token)
+	t.Cleanup(server.Close)
+	server.AddJar(api.Jar{ID: "jar1", Title: "Diesel", CurrencyCode: 980})
+
+	dir := t.TempDir()
+	o := service.Options{
+		Tenant:     "house",
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     token,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		// a single statement window
+		JarStart: time.Now().Add(-24 * time.Hour).Format(config.JarStartLayout),
+	})
+	require.NoError(t, err)
+	return server, o
+}
+
+// startService runs the service until the returned stop is called or the test ends.
+func startService(t *testing.T, m *DiesGenService) (chan<- Command, func()) {
+	commands := make(chan Command)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.Run(commands, func(State) {})
+	}()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			commands <- CommandStop
+			<-done
+		})
+	}
+	t.Cleanup(stop)
+	return commands, stop
+}
+
+func syncs(server *fake.Server) func() int {
+	return func() int {
+		return server.Requests("/personal/client-info")
+	}
+}
+
+func TestRunSkipsTicksWhilePaused(t *testing.T) {
+	server, o := newTestTenant(t)
+	count := syncs(server)
+
+	pauseFile := filepath.Join(t.TempDir(), "paused")
+	require.NoError(t, os.WriteFile(pauseFile, []byte("paused\n"), 0o644))
+	m := NewDiesGenService(o)
+	m.PauseFile = pauseFile
+	m.CatchUp = true
+	commands, _ := startService(t, m)
+
+	// the tick on start and requested syncs are skipped
+	commands <- CommandSync
+	assert.Never(t, func() bool { return count() > 0 }, quietFor, tick)
+	assert.True(t, m.paused())
+
+	// the skipped tick is caught up
+	commands <- CommandContinue
+	require.Eventually(t, func() bool { return count() == 1 }, waitFor, tick)
+	assert.False(t, m.paused())
+	assert.NoFileExists(t, pauseFile)
+	assert.Never(t, func() bool { return count() > 1 }, quietFor, tick)
+}
+
+func TestRunCatchesUpOnlySkippedTicks(t *testing.T) {
+	t.Run("nothing skipped", func(t *testing.T) {
+		server, o := newTestTenant(t)
+		count := syncs(server)
+
+		m := NewDiesGenService(o)
+		m.PauseFile = filepath.Join(t.TempDir(), "paused")
+		m.CatchUp = true
+		commands, _ := startService(t, m)
+		require.Eventually(t, func() bool { return count() == 1 }, waitFor, tick)
+
+		commands <- CommandPause
+		require.Eventually(t, m.paused, waitFor, tick)
+		// only a pause through the admin api is kept across restarts
+		assert.NoFileExists(t, m.PauseFile)
+
+		commands <- CommandContinue
+		require.Eventually(t, func() bool { return !m.paused() }, waitFor, tick)
+		assert.Never(t, func() bool { return count() > 1 }, quietFor, tick)
+	})
+
+	t.Run("catch up disabled", func(t *testing.T) {
+		server, o := newTestTenant(t)
+		count := syncs(server)
+
+		pauseFile := filepath.Join(t.TempDir(), "paused")
+		require.NoError(t, os.WriteFile(pauseFile, []byte("paused\n"), 0o644))
+		m := NewDiesGenService(o)
+		m.PauseFile = pauseFile
+		commands, _ := startService(t, m)
+		assert.Never(t, func() bool { return count() > 0 }, quietFor, tick)
+
+		commands <- CommandContinue
+		require.Eventually(t, func() bool { return !m.paused() }, waitFor, tick)
+		assert.Never(t, func() bool { return count() > 0 }, quietFor, tick)
+	})
+}
+
+func TestRunKeepsPauseAcrossRestarts(t *testing.T) {
+	server, o := newTestTenant(t)
+	count := syncs(server)
+	pauseFile := filepath.Join(t.TempDir(), "paused")
+
+	m := NewDiesGenService(o)
+	m.PauseFile = pauseFile
+	_, stop := startService(t, m)
+	require.Eventually(t, func() bool { return count() == 1 }, waitFor, tick)
+
+	// paused through the admin api
+	require.NoError(t, m.pause(true))
+	assert.FileExists(t, pauseFile)
+	stop()
+
+	m = NewDiesGenService(o)
+	m.PauseFile = pauseFile
+	commands, _ := startService(t, m)
+	require.Eventually(t, m.paused, waitFor, tick)
+	assert.Never(t, func() bool { return count() > 1 }, quietFor, tick)
+
+	commands <- CommandContinue
+	require.Eventually(t, func() bool { return !m.paused() }, waitFor, tick)
+	assert.NoFileExists(t, pauseFile)
+}
@@ -0,0 +1,42 @@
+// Package logging carries the log entry of a run in its context and names the fields
+// shared by every package, so the logs of a sync can be queried by them.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	log "github.com/sirupsen/logrus"
+)
+
+// Field names of the log entries.
+const (
+	Tenant      = "tenant"
+	Run         = "run_id"
+	Campaign    = "campaign"
+	Transaction = "transaction_id"
+	Flat        = "flat"
+	Amount      = "amount"
+)
+
+type entryKey struct{}
+
+// WithEntry returns ctx carrying the log entry.
+func WithEntry(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// Entry returns the log entry of ctx, an entry of the standard logger when it carries none.
+func Entry(ctx context.Context) *log.Entry {
+	if entry, ok := ctx.Value(entryKey{}).(*log.Entry); ok {
+		return entry
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// NewRunID returns a random id correlating the log entries of a run.
+func NewRunID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
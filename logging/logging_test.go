@@ -0,0 +1,20 @@
+package logging
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEntry(t *testing.T) {
+	assert.NotNil(t, Entry(context.Background()))
+
+	entry := log.WithField(Run, NewRunID())
+	ctx := WithEntry(context.Background(), entry)
+	assert.Same(t, entry, Entry(ctx))
+
+	id := NewRunID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, NewRunID())
+}
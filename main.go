@@ -1,77 +1,350 @@
 package main
 
 import (
+	"diesgen/config"
+	"diesgen/safefile"
+	"diesgen/service"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/natefinch/lumberjack"
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/sys/windows/svc"
-	"golang.org/x/sys/windows/svc/debug"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 )
 
 const (
-	serviceName   = "diesgen"
-	debugFilesDir = `C:\Users\alexm\Documents\private\logs\test`
+	serviceName = "diesgen"
 )
 
 var (
-	debugLog      = filepath.Join(debugFilesDir, "diesgen.log")
-	debugXlsx     = filepath.Join(debugFilesDir, "diesgen.xlsx")
-	debugConfPath = filepath.Join(debugFilesDir, "config.json")
+	debugLog      = filepath.Join(defaultFilesDir, "diesgen.log")
+	debugXlsx     = filepath.Join(defaultFilesDir, "diesgen.xlsx")
+	debugConfPath = filepath.Join(defaultFilesDir, "config.json")
+	debugStore    = filepath.Join(defaultFilesDir, "diesgen.db")
 )
 
+// unitParams are the values substituted into the generated systemd unit file.
+type unitParams struct {
+	Name       string
+	Executable string
+	ConfigPath string
+	XlsxPath   string
+	StorePath  string
+	LogPath    string
+	// TenantsPath replaces the config, xlsx and store paths when set
+	TenantsPath string
+}
+
+// command is a subcommand of the binary, run receives the arguments following its name.
+type command struct {
+	name  string
+	usage string
+	run   func(args []string, in io.Reader, out io.Writer) error
+}
+
+var commands = []command{
+	{"run", "run the service syncing the jars on the config schedule (interval or cron, quiet hours, jitter), the default", runServiceCommand},
+	{"sync", "sync the jar once and exit", runSync},
+	{"validate-config", "check the config and its comment rules", runValidateConfig},
+	{"rebuild-xlsx", "attribute the stored transactions again and regenerate the workbook", runRebuildXlsx},
+	{"show-jar", "list the jars of the token to pick jarName from", runShowJar},
+	{"report", "print the income of every flat from the store", runReport},
+	{"exclusions", "list and resolve payments without a flat", runExclusions},
+	{"counterparties", "list the learned payer flats and forget a wrong one", runCounterparties},
+	{"test-rules", "print the comment rule matching each argument or stdin line", runTestRules},
+	{"systemd-unit", "print a systemd unit file for the given paths", runSystemdUnit},
+}
+
+// errUsage is returned by commands called with invalid arguments.
+var errUsage = errors.New("invalid usage")
+
 func main() {
-	logPath := flag.String("log", debugLog, "log file path")
-	configPath := flag.String("config", debugConfPath, "config file path")
-	xlsxPath := flag.String("xlsx", debugXlsx, "xlsx file path")
-	flag.Parse()
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage(os.Stdout)
+		return
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(args, os.Stdin, os.Stdout)
+		switch {
+		case err == nil, errors.Is(err, flag.ErrHelp):
+		case errors.Is(err, errUsage):
+			os.Exit(2)
+		default:
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage(os.Stderr)
+	os.Exit(2)
+}
+
+func usage(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Usage: %s [command] [flags]\n\nCommands:\n", serviceName)
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(w, "  %-16s %s\n", c.name, c.usage)
+	}
+	_, _ = fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", serviceName)
+}
+
+// globalFlags are the flags shared by every command.
+type globalFlags struct {
+	LogPath    string
+	LogFormat  string
+	LogLevel   string
+	ConfigPath string
+	XlsxPath   string
+	StorePath  string
+	Backups    int
+	// TenantsPath lists the buildings served by one process, Tenant selects one of them
+	TenantsPath string
+	Tenant      string
+	Timeouts    service.Timeouts
 
-	logFile := &lumberjack.Logger{
-		Filename:   *logPath,
+	// allTenants is set by commands serving every tenant when none is selected
+	allTenants bool
+	tenants    []config.Tenant
+}
+
+// newFlagSet returns the flag set of the command with the shared flags registered.
+func newFlagSet(name string) (*flag.FlagSet, *globalFlags) {
+	g := &globalFlags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&g.LogPath, "log", debugLog, "log file path")
+	fs.StringVar(&g.LogFormat, "log-format", "text", "log entry format, text or json")
+	fs.StringVar(&g.LogLevel, "log-level", "info", "lowest level logged: debug, info, warn or error")
+	fs.StringVar(&g.ConfigPath, "config", debugConfPath, "config file path")
+	fs.StringVar(&g.XlsxPath, "xlsx", debugXlsx, "xlsx file path")
+	fs.StringVar(&g.StorePath, "store", debugStore, "transaction store file path")
+	fs.IntVar(&g.Backups, "backups", safefile.Backups, "number of rotated config and xlsx backups to keep")
+	fs.StringVar(&g.TenantsPath, "tenants", "", "tenants file listing the config, xlsx and store of every building")
+	fs.StringVar(&g.Tenant, "tenant", "", "name of the tenant in the -tenants file to use")
+	fs.DurationVar(&g.Timeouts.Request, "request-timeout", service.DefaultTimeouts.Request, "timeout of a single monobank api request")
+	fs.DurationVar(&g.Timeouts.Fetch, "fetch-timeout", service.DefaultTimeouts.Fetch, "timeout of fetching the statement of a campaign including rate limit waits")
+	fs.DurationVar(&g.Timeouts.Write, "write-timeout", service.DefaultTimeouts.Write, "timeout of attributing a campaign and writing its xlsx")
+	fs.DurationVar(&g.Timeouts.Sync, "sync-timeout", service.DefaultTimeouts.Sync, "timeout of a whole sync")
+	return fs, g
+}
+
+// parse parses the command line of the command and sets up logging, log entries
+// are written to console as well when it is not nil.
+func (g *globalFlags) parse(fs *flag.FlagSet, args []string, console io.Writer) error {
+	err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	err = g.loadTenants()
+	if err != nil {
+		return err
+	}
+	formatter, err := newFormatter(g.LogFormat)
+	if err != nil {
+		return err
+	}
+	level, err := log.ParseLevel(g.LogLevel)
+	if err != nil {
+		return err
+	}
+	setupLogging(g.LogPath, console, formatter, level)
+	safefile.Backups = g.Backups
+	return nil
+}
+
+// parseFlags parses args, errors other than a help request are reported by fs already.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil && !errors.Is(err, flag.ErrHelp) {
+		return errUsage
+	}
+	return err
+}
+
+// loadTenants reads the -tenants file, the paths of the tenant selected with -tenant replace the path flags.
+func (g *globalFlags) loadTenants() error {
+	if g.TenantsPath == "" {
+		if g.Tenant != "" {
+			return errors.New("-tenant requires -tenants")
+		}
+		return nil
+	}
+
+	tenants, err := config.GetTenants(g.TenantsPath)
+	if err != nil {
+		return err
+	}
+	if g.Tenant == "" {
+		if !g.allTenants {
+			return errors.New("-tenant is required with -tenants")
+		}
+		g.tenants = tenants
+		return nil
+	}
+
+	i := slices.IndexFunc(tenants, func(t config.Tenant) bool {
+		return t.Name == g.Tenant
+	})
+	if i < 0 {
+		return fmt.Errorf("tenant %s not found in %s", g.Tenant, g.TenantsPath)
+	}
+	g.tenants = tenants[i : i+1]
+	g.ConfigPath, g.XlsxPath, g.StorePath = tenants[i].Config, tenants[i].Xlsx, tenants[i].Store
+	return nil
+}
+
+func (g *globalFlags) options() service.Options {
+	return service.Options{Tenant: g.Tenant, ConfigPath: g.ConfigPath, XlsxPath: g.XlsxPath, StorePath: g.StorePath, Timeouts: g.Timeouts}
+}
+
+// instances returns the options of every tenant to serve, the path flags are the only one without -tenants.
+func (g *globalFlags) instances() []service.Options {
+	if len(g.tenants) == 0 {
+		return []service.Options{g.options()}
+	}
+	var instances []service.Options
+	for _, t := range g.tenants {
+		instances = append(instances, service.Options{Tenant: t.Name, ConfigPath: t.Config, XlsxPath: t.Xlsx, StorePath: t.Store, Timeouts: g.Timeouts})
+	}
+	return instances
+}
+
+// pauseFile returns the file keeping the service paused across restarts, it is next to the tenants file or the config.
+func (g *globalFlags) pauseFile() string {
+	path := g.ConfigPath
+	if g.TenantsPath != "" {
+		path = g.TenantsPath
+	}
+	return filepath.Join(filepath.Dir(path), serviceName+".paused")
+}
+
+// newFormatter returns the log formatter of the -log-format, json entries can be shipped to log
+// stores as they are.
+func newFormatter(format string) (log.Formatter, error) {
+	callerPrettyfier := func(frame *runtime.Frame) (string, string) {
+		funcName := filepath.Base(frame.Function)
+		fileName := filepath.Base(frame.File)
+		return funcName, fmt.Sprintf("%s:%d", fileName, frame.Line)
+	}
+
+	switch format {
+	case "text":
+		return &log.TextFormatter{FullTimestamp: true, CallerPrettyfier: callerPrettyfier}, nil
+	case "json":
+		return &log.JSONFormatter{TimestampFormat: time.RFC3339Nano, CallerPrettyfier: callerPrettyfier}, nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q, expected text or json", format)
+	}
+}
+
+func setupLogging(logPath string, console io.Writer, formatter log.Formatter, level log.Level) {
+	var out io.Writer = &lumberjack.Logger{
+		Filename:   logPath,
 		MaxSize:    10, // Megabytes
 		MaxBackups: 2,
 		MaxAge:     28,   // Days
 		Compress:   true, // Compress rotated files
 	}
+	if console != nil {
+		// the console goes first, io.MultiWriter stops at the first failing writer
+		out = io.MultiWriter(console, out)
+	}
 
-	log.SetOutput(io.MultiWriter(logFile))
+	log.SetOutput(out)
 	log.SetReportCaller(true)
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-		CallerPrettyfier: func(frame *runtime.Frame) (string, string) {
-			funcName := filepath.Base(frame.Function)
-			fileName := filepath.Base(frame.File)
-			return funcName, fmt.Sprintf("%s:%d", fileName, frame.Line)
-		},
-	})
+	log.SetFormatter(formatter)
+	log.SetLevel(level)
+}
+
+func runServiceCommand(args []string, _ io.Reader, _ io.Writer) error {
+	fs, g := newFlagSet("run")
+	webhook := fs.String("webhook", "", "listen address of the webhook receiver for the configs with webhookUrl, e.g. :8080")
+	admin := fs.String("admin", "", "listen address of the local admin api and prometheus /metrics, e.g. 127.0.0.1:9100")
+	catchUp := fs.Bool("catch-up", true, "sync on resume when syncs were skipped while paused")
+	g.allTenants = true
+	if err := g.parse(fs, args, nil); err != nil {
+		return err
+	}
 
 	log.Infof("Starting service %s", serviceName)
-	log.Infof("Log path: %s", *logPath)
-	log.Infof("Config path: %s", *configPath)
-	log.Infof("Xlsx path: %s", *xlsxPath)
+	log.Infof("Log path: %s", g.LogPath)
+	instances := g.instances()
+	for _, o := range instances {
+		l := o.Logger()
+		l.Infof("Config path: %s", o.ConfigPath)
+		l.Infof("Xlsx path: %s", o.XlsxPath)
+		l.Infof("Store path: %s", o.StorePath)
+	}
 
-	inService, err := svc.IsWindowsService()
+	interactive, err := isInteractive()
 	if err != nil {
 		log.Fatalf("failed to determine if we are running in service: %v", err)
 	}
 
-	run := svc.Run
-	if !inService {
+	if interactive {
 		log.SetOutput(os.Stdout)
 		log.Info("Starting in debug mode")
-		run = debug.Run
 	} else {
 		log.Info("Starting in service mode")
 	}
 
-	err = run(serviceName, NewDiesGenService(*configPath, *xlsxPath))
+	s := NewDiesGenService(instances...)
+	s.WebhookAddr = *webhook
+	s.AdminAddr = *admin
+	s.PauseFile = g.pauseFile()
+	s.CatchUp = *catchUp
+	err = runService(serviceName, s, interactive)
 	if err != nil {
-		log.Error("run %s service failed: %v", serviceName, err)
-		return
+		log.Errorf("run %s service failed: %v", serviceName, err)
+		return err
+	}
+	log.Infof("%s service stopped", serviceName)
+	return nil
+}
+
+func runSystemdUnit(args []string, _ io.Reader, out io.Writer) error {
+	fs, g := newFlagSet("systemd-unit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	abs := func(p string) string {
+		if a, err := filepath.Abs(p); err == nil {
+			return a
+		}
+		return p
+	}
+
+	p := unitParams{
+		Name:       serviceName,
+		Executable: executable,
+		ConfigPath: abs(g.ConfigPath),
+		XlsxPath:   abs(g.XlsxPath),
+		StorePath:  abs(g.StorePath),
+		LogPath:    abs(g.LogPath),
+	}
+	if g.TenantsPath != "" {
+		p.TenantsPath = abs(g.TenantsPath)
 	}
-	log.Info("%s service stopped", serviceName)
+	return writeSystemdUnit(out, p)
 }
@@ -0,0 +1,184 @@
+// Package metrics keeps counters and gauges and exposes them in the prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds metric families by name.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// Default is the registry of the process.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+type family struct {
+	registry *Registry
+	name     string
+	help     string
+	kind     string
+	labels   []string
+	// samples are keyed by the joined label values
+	samples map[string]*sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// Counter is a metric family that only goes up.
+type Counter struct {
+	f *family
+}
+
+// Gauge is a metric family set to the current value.
+type Gauge struct {
+	f *family
+}
+
+// NewCounter registers a counter with the label names.
+func (r *Registry) NewCounter(name string, help string, labels ...string) Counter {
+	return Counter{r.register(name, help, "counter", labels)}
+}
+
+// NewGauge registers a gauge with the label names.
+func (r *Registry) NewGauge(name string, help string, labels ...string) Gauge {
+	return Gauge{r.register(name, help, "gauge", labels)}
+}
+
+func (r *Registry) register(name string, help string, kind string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.families[name]; ok {
+		panic("metrics: " + name + " registered twice")
+	}
+	f := &family{registry: r, name: name, help: help, kind: kind, labels: labels, samples: make(map[string]*sample)}
+	r.families[name] = f
+	return f
+}
+
+// Inc adds one to the counter with the label values.
+func (c Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v to the counter with the label values, negative values are ignored.
+func (c Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.update(labels, func(s *sample) {
+		s.value += v
+	})
+}
+
+// Set sets the gauge with the label values.
+func (g Gauge) Set(v float64, labels ...string) {
+	g.f.update(labels, func(s *sample) {
+		s.value = v
+	})
+}
+
+// Delete removes the samples whose leading label values are labels, e.g. of every currency
+// of a campaign removed from the config.
+func (g Gauge) Delete(labels ...string) {
+	g.f.registry.mu.Lock()
+	defer g.f.registry.mu.Unlock()
+	for key, s := range g.f.samples {
+		if len(s.labels) >= len(labels) && slices.Equal(s.labels[:len(labels)], labels) {
+			delete(g.f.samples, key)
+		}
+	}
+}
+
+func (f *family) update(labels []string, change func(*sample)) {
+	if len(labels) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", f.name, len(f.labels), len(labels)))
+	}
+
+	f.registry.mu.Lock()
+	defer f.registry.mu.Unlock()
+	key := strings.Join(labels, "\xff")
+	s, ok := f.samples[key]
+	if !ok {
+		s = &sample{labels: slices.Clone(labels)}
+		f.samples[key] = s
+	}
+	change(s)
+}
+
+// Write writes every family with samples in the prometheus text format ordered by name and labels.
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		f := r.families[name]
+		if len(f.samples) == 0 {
+			continue
+		}
+		_, _ = fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+
+		keys := make([]string, 0, len(f.samples))
+		for key := range f.samples {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			s := f.samples[key]
+			_, _ = bw.WriteString(f.name)
+			if len(f.labels) > 0 {
+				_ = bw.WriteByte('{')
+				for i, l := range f.labels {
+					if i > 0 {
+						_ = bw.WriteByte(',')
+					}
+					_, _ = fmt.Fprintf(bw, "%s=\"%s\"", l, escapeLabel(s.labels[i]))
+				}
+				_ = bw.WriteByte('}')
+			}
+			_, _ = fmt.Fprintf(bw, " %s\n", strconv.FormatFloat(s.value, 'g', -1, 64))
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.Write(w)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
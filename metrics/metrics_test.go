@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	requests := r.NewCounter("test_requests_total", "Requests by status.", "endpoint", "status")
+	balance := r.NewGauge("test_balance", "Balance\nin UAH.", "jar")
+	r.NewGauge("test_unused", "Never set.")
+
+	requests.Inc("statement", "200")
+	requests.Add(2, "statement", "200")
+	requests.Inc("client-info", "429")
+	requests.Add(-1, "client-info", "429")
+	balance.Set(1234.5, `Дизель "1"`)
+	balance.Set(10, "removed")
+	balance.Delete("removed")
+	jars := r.NewGauge("test_jars", "Jars by currency.", "campaign", "currency")
+	jars.Set(1, "removed", "UAH")
+	jars.Set(2, "removed", "USD")
+	jars.Delete("removed")
+
+	var b strings.Builder
+	require.NoError(t, r.Write(&b))
+	assert.Equal(t, `# HELP test_balance Balance\nin UAH.
+# TYPE test_balance gauge
+test_balance{jar="Дизель \"1\""} 1234.5
+# HELP test_requests_total Requests by status.
+# TYPE test_requests_total counter
+test_requests_total{endpoint="client-info",status="429"} 1
+test_requests_total{endpoint="statement",status="200"} 3
+`, b.String())
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.Equal(t, b.String(), rec.Body.String())
+
+	assert.Panics(t, func() {
+		requests.Inc("statement")
+	})
+}
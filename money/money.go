@@ -0,0 +1,190 @@
+// Package money keeps amounts in minor units so kopecks are never lost to float rounding.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ISO 4217 numeric currency codes used by monobank.
+const (
+	UAH = 980
+	USD = 840
+	EUR = 978
+)
+
+var currencySymbols = map[int]string{
+	UAH: "UAH",
+	USD: "USD",
+	EUR: "EUR",
+}
+
+// Money is an amount in minor units (kopecks, cents) of the currency.
+type Money struct {
+	Amount   int64
+	Currency int
+}
+
+// New returns amount minor units of currency, UAH when currency is 0.
+func New(amount int64, currency int) Money {
+	if currency == 0 {
+		currency = UAH
+	}
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ErrCurrencyMismatch is returned for arithmetic on amounts of different currencies.
+var ErrCurrencyMismatch = errors.New("currency mismatch")
+
+// Add returns m + o. The currency of a zero value is taken from the other operand,
+// amounts of different currencies are not added.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency == 0 {
+		m.Currency = o.Currency
+	}
+	if o.Currency != 0 && o.Currency != m.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m, o)
+	}
+	m.Amount += o.Amount
+	return m, nil
+}
+
+// Sub returns m - o.
+func (m Money) Sub(o Money) (Money, error) {
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	m.Amount = -m.Amount
+	return m
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Float returns the amount in major units, it is meant for display only.
+func (m Money) Float() float64 {
+	return float64(m.Amount) / 100
+}
+
+// Decimal formats the amount in major units with two fraction digits, e.g. "-150.05".
+func (m Money) Decimal() string {
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// CurrencySymbol returns the ISO 4217 alphabetic code or the numeric one when unknown.
+func (m Money) CurrencySymbol() string {
+	if s, ok := currencySymbols[m.Currency]; ok {
+		return s
+	}
+	return strconv.Itoa(m.Currency)
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.CurrencySymbol()
+}
+
+// Parse reads a decimal amount in major units like "150", "150.5" or "-1 234,50".
+func Parse(s string, currency int) (Money, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), " ", "")
+	s = strings.ReplaceAll(s, ",", ".")
+	if s == "" {
+		return Money{}, errors.New("empty amount")
+	}
+
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, fraction, _ := strings.Cut(s, ".")
+	if len(fraction) > 2 {
+		return Money{}, fmt.Errorf("invalid amount %q: more than two fraction digits", s)
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+	minor, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %w", s, err)
+	}
+
+	amount := major*100 + minor
+	if negative {
+		amount = -amount
+	}
+	return New(amount, currency), nil
+}
+
+// FromFloat converts major units read from a spreadsheet cell rounding to the nearest minor unit.
+func FromFloat(f float64, currency int) Money {
+	return New(int64(math.Round(f*100)), currency)
+}
+
+// MarshalJSON writes money as a string like "150.50 UAH".
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON reads "150.50 UAH", "150.50" or a plain number of whole hryvnias
+// as written by older versions.
+func (m *Money) UnmarshalJSON(b []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(b, &number); err == nil {
+		parsed, err := Parse(number.String(), UAH)
+		if err != nil {
+			return err
+		}
+		*m = parsed
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	amount, symbol, _ := strings.Cut(strings.TrimSpace(s), " ")
+	currency, err := parseCurrency(symbol)
+	if err != nil {
+		return err
+	}
+	parsed, err := Parse(amount, currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func parseCurrency(symbol string) (int, error) {
+	symbol = strings.TrimSpace(symbol)
+	if symbol == "" {
+		return UAH, nil
+	}
+	for code, s := range currencySymbols {
+		if strings.EqualFold(s, symbol) {
+			return code, nil
+		}
+	}
+	code, err := strconv.Atoi(symbol)
+	if err != nil {
+		return 0, fmt.Errorf("unknown currency %q", symbol)
+	}
+	return code, nil
+}
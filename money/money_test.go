@@ -0,0 +1,75 @@
+package money
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"150":       15000,
+		"150.5":     15050,
+		"150.05":    15005,
+		"-0.05":     -5,
+		"1 234,50":  123450,
+		"-1234.99 ": -123499,
+	} {
+		m, err := Parse(s, UAH)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, m.Amount, s)
+	}
+
+	for _, s := range []string{"", "1.234", "abc", "1.2.3"} {
+		_, err := Parse(s, UAH)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	assert.Equal(t, "150.50", New(15050, UAH).Decimal())
+	assert.Equal(t, "-0.05", New(-5, UAH).Decimal())
+	assert.Equal(t, "0.00", Money{}.Decimal())
+	assert.Equal(t, "10.00 USD", New(1000, USD).String())
+}
+
+func TestAdd(t *testing.T) {
+	// the zero value takes the currency of the other operand
+	sum, err := Money{}.Add(New(15050, USD))
+	require.NoError(t, err)
+	assert.Equal(t, New(15050, USD), sum)
+
+	sum, err = sum.Sub(New(50, USD))
+	require.NoError(t, err)
+	assert.Equal(t, New(15000, USD), sum)
+	assert.Equal(t, New(-15000, USD), sum.Neg())
+
+	_, err = sum.Add(New(100, UAH))
+	require.ErrorIs(t, err, ErrCurrencyMismatch)
+	assert.Contains(t, err.Error(), "150.00 USD and 1.00 UAH")
+	_, err = New(100, UAH).Sub(New(100, EUR))
+	assert.ErrorIs(t, err, ErrCurrencyMismatch)
+}
+
+func TestFromFloat(t *testing.T) {
+	assert.Equal(t, int64(15050), FromFloat(150.50000000000003, UAH).Amount)
+	assert.Equal(t, int64(1), FromFloat(0.1+0.2-0.29, UAH).Amount)
+}
+
+func TestJSON(t *testing.T) {
+	var v struct {
+		Legacy  Money `json:"legacy"`
+		Decimal Money `json:"decimal"`
+		Foreign Money `json:"foreign"`
+	}
+	err := json.Unmarshal([]byte(`{"legacy": 1100, "decimal": "150.50", "foreign": "2.10 USD"}`), &v)
+	require.NoError(t, err)
+	assert.Equal(t, New(110000, UAH), v.Legacy)
+	assert.Equal(t, New(15050, UAH), v.Decimal)
+	assert.Equal(t, New(210, USD), v.Foreign)
+
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"legacy": "1100.00 UAH", "decimal": "150.50 UAH", "foreign": "2.10 USD"}`, string(b))
+}
@@ -0,0 +1,67 @@
+package rules
+
+import (
+	"diesgen/api"
+	"diesgen/config"
+	"fmt"
+	"regexp"
+	"slices"
+)
+
+// OtherCategory is the category of withdrawals no category matches.
+const OtherCategory = "Other"
+
+// DefaultExpenseCategories are tried after the configured ones.
+var DefaultExpenseCategories = []config.ExpenseCategory{
+	// service stations, automated fuel dispensers and fuel dealers
+	{Name: "Fuel", MCCs: []int{5541, 5542, 5983}, Pattern: `(?i)дизел|пальн|солярк|diesel|fuel`},
+	{Name: "Generator service", Pattern: `(?i)генератор|generator`},
+	{Name: "Cash", MCCs: []int{6010, 6011}},
+}
+
+type expenseCategory struct {
+	name string
+	mccs []int
+	re   *regexp.Regexp
+}
+
+// Expenses assigns categories to withdrawals.
+type Expenses struct {
+	categories []expenseCategory
+}
+
+// NewExpenses compiles the categories, the first matching one wins.
+func NewExpenses(categories []config.ExpenseCategory) (*Expenses, error) {
+	e := &Expenses{}
+	for _, c := range categories {
+		category := expenseCategory{name: c.Name, mccs: c.MCCs}
+		if c.Pattern != "" {
+			re, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("expense category %s: %w", c.Name, err)
+			}
+			category.re = re
+		}
+		e.categories = append(e.categories, category)
+	}
+	return e, nil
+}
+
+// ExpensesFromConfig returns the configured categories followed by DefaultExpenseCategories.
+func ExpensesFromConfig(c *config.Config) (*Expenses, error) {
+	return NewExpenses(append(slices.Clone(c.Expenses), DefaultExpenseCategories...))
+}
+
+// Category returns the name of the first category matching the transaction MCC,
+// description or comment, OtherCategory when none does.
+func (e *Expenses) Category(t api.Transaction) string {
+	for _, c := range e.categories {
+		if slices.Contains(c.mccs, t.MCC) {
+			return c.name
+		}
+		if c.re != nil && (c.re.MatchString(t.Description) || c.re.MatchString(t.Comment)) {
+			return c.name
+		}
+	}
+	return OtherCategory
+}
@@ -0,0 +1,199 @@
+// Package rules extracts the flat and card from payment comments.
+package rules
+
+import (
+	"diesgen/config"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// KeywordRule is the name of the rule built from config.Parsing.Keywords.
+	KeywordRule = "keyword"
+	// DigitsRule is the name of the fallback rule taking the first number as the flat
+	// and the second one as the card.
+	DigitsRule = "digits"
+
+	defaultFlatMax = 9999
+)
+
+var DefaultKeywords = []string{"квартира", "кв", "apt", "flat"}
+
+var (
+	digitsRe = regexp.MustCompile(`\d+`)
+	cardRe   = regexp.MustCompile(`\d{16}|\d{6}\*{6}\d{4}`)
+)
+
+// Match is the result of the first rule matching a comment.
+type Match struct {
+	Rule string
+	Flat int
+	Card string
+}
+
+type rule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Engine applies the keyword rule, the configured rules in order and the digits rule
+// until one of them yields a flat within the allowed range.
+type Engine struct {
+	rules   []rule
+	flatMin int
+	flatMax int
+	// flats restricts matches to registered flats when not nil
+	flats map[int]bool
+}
+
+// New compiles the parsing section of the config.
+func New(p config.Parsing) (*Engine, error) {
+	e := &Engine{flatMin: p.FlatMin, flatMax: p.FlatMax}
+	if e.flatMax == 0 {
+		e.flatMax = defaultFlatMax
+	}
+	if e.flatMin > e.flatMax {
+		return nil, fmt.Errorf("invalid flat range %d-%d", e.flatMin, e.flatMax)
+	}
+
+	keywords := p.Keywords
+	if len(keywords) == 0 {
+		keywords = DefaultKeywords
+	}
+	quoted := make([]string, 0, len(keywords))
+	for _, k := range keywords {
+		quoted = append(quoted, regexp.QuoteMeta(k))
+	}
+	// \b is ascii only, so cyrillic keywords are bounded by a non letter explicitly
+	keywordRe, err := regexp.Compile(`(?i)(?:^|[^\p{L}])(?:` + strings.Join(quoted, "|") +
+		`)\.?\s*[:№#-]?\s*(?P<flat>\d{1,4})(?:\D|$)`)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", KeywordRule, err)
+	}
+	e.rules = append(e.rules, rule{name: KeywordRule, re: keywordRe})
+
+	for i, r := range p.Rules {
+		name := r.Name
+		if name == "" {
+			name = "rule" + strconv.Itoa(i+1)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		if re.SubexpIndex("flat") < 0 {
+			return nil, fmt.Errorf("rule %s: pattern has no flat group", name)
+		}
+		e.rules = append(e.rules, rule{name: name, re: re})
+	}
+	return e, nil
+}
+
+// Default returns the engine used when the config has no parsing section.
+func Default() *Engine {
+	e, err := New(config.Parsing{})
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+// FromConfig returns the engine configured in c, only registered flats are accepted
+// when the config has a flat registry.
+func FromConfig(c *config.Config) (*Engine, error) {
+	e, err := New(c.Parsing)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Flats) > 0 {
+		e.flats = make(map[int]bool, len(c.Flats))
+		for _, n := range c.FlatNumbers() {
+			e.flats[n] = true
+		}
+	}
+	return e, nil
+}
+
+// Parse returns the first match of the comment.
+func (e *Engine) Parse(comment string) (*Match, error) {
+	for _, r := range e.rules {
+		m, ok := r.match(comment)
+		if ok && e.allowed(m.Flat) {
+			return m, nil
+		}
+	}
+
+	m, err := digits(comment)
+	if err != nil {
+		return nil, err
+	}
+	if !e.allowed(m.Flat) {
+		if e.flats != nil {
+			return nil, fmt.Errorf("flat %d is not registered", m.Flat)
+		}
+		return nil, fmt.Errorf("flat %d is out of range %d-%d", m.Flat, e.flatMin, e.flatMax)
+	}
+	return m, nil
+}
+
+// Card returns the card number or masked card found in the comment.
+func Card(comment string) string {
+	return cardRe.FindString(comment)
+}
+
+func (e *Engine) allowed(flat int) bool {
+	if e.flats != nil {
+		return e.flats[flat]
+	}
+	return flat >= e.flatMin && flat <= e.flatMax
+}
+
+func (r rule) match(comment string) (*Match, bool) {
+	groups := r.re.FindStringSubmatch(comment)
+	if groups == nil {
+		return nil, false
+	}
+
+	group := func(name string) string {
+		if i := r.re.SubexpIndex(name); i >= 0 {
+			return groups[i]
+		}
+		return ""
+	}
+
+	flat, err := strconv.Atoi(group("flat"))
+	if err != nil {
+		return nil, false
+	}
+
+	m := &Match{Rule: r.name, Flat: flat, Card: group("card")}
+	if m.Card == "" {
+		m.Card = cardRe.FindString(comment)
+	}
+	return m, true
+}
+
+func digits(comment string) (*Match, error) {
+	matches := digitsRe.FindAllString(comment, -1)
+	if len(matches) == 0 {
+		return nil, errors.New("input does not contain flat or card")
+	}
+
+	flat, err := strconv.Atoi(matches[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid flat number: %w", err)
+	}
+	if len(strconv.Itoa(flat)) > 4 {
+		return nil, fmt.Errorf("invalid flat number: %s", matches[0])
+	}
+
+	var card string
+	if len(matches) > 1 {
+		card = matches[1]
+	}
+
+	return &Match{Rule: DigitsRule, Flat: flat, Card: card}, nil
+}
@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"diesgen/api"
+	"diesgen/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestDefaultEngine(t *testing.T) {
+	e := Default()
+
+	for comment, expected := range map[string]Match{
+		"155 4441114420563932":          {Rule: DigitsRule, Flat: 155, Card: "4441114420563932"},
+		"кв:155 4441114420563932":       {Rule: KeywordRule, Flat: 155, Card: "4441114420563932"},
+		"оплата за 2 місяці кв 45":      {Rule: KeywordRule, Flat: 45},
+		"Квартира 7, дякую":             {Rule: KeywordRule, Flat: 7},
+		"apt.12":                        {Rule: KeywordRule, Flat: 12},
+		"ключ 3 від кв. 8":              {Rule: KeywordRule, Flat: 8},
+		"за 2 місяці, 4441114420563932": {Rule: DigitsRule, Flat: 2, Card: "4441114420563932"},
+	} {
+		m, err := e.Parse(comment)
+		require.NoError(t, err, comment)
+		assert.Equal(t, expected, *m, comment)
+	}
+
+	for _, comment := range []string{"", "дякую", "12345"} {
+		_, err := e.Parse(comment)
+		assert.Error(t, err, comment)
+	}
+}
+
+func TestConfiguredRules(t *testing.T) {
+	e, err := New(config.Parsing{
+		Keywords: []string{"кв"},
+		Rules: []config.Rule{
+			{Name: "months", Pattern: `(?P<months>\d+)\s*міс\S*\s+(?P<flat>\d+)\s*(?P<card>\d{4})?`},
+		},
+		FlatMin: 1,
+		FlatMax: 200,
+	})
+	require.NoError(t, err)
+
+	m, err := e.Parse("3 міс 120 5555")
+	require.NoError(t, err)
+	assert.Equal(t, Match{Rule: "months", Flat: 120, Card: "5555"}, *m)
+
+	// keywords take precedence over the configured rules
+	m, err = e.Parse("2 місяці 150 кв 45")
+	require.NoError(t, err)
+	assert.Equal(t, Match{Rule: KeywordRule, Flat: 45}, *m)
+
+	// a keyword match out of range falls through to the next rules
+	m, err = e.Parse("кв 500, 1 міс 20")
+	require.NoError(t, err)
+	assert.Equal(t, Match{Rule: "months", Flat: 20}, *m)
+
+	_, err = e.Parse("0")
+	assert.Error(t, err)
+	_, err = e.Parse("201")
+	assert.Error(t, err)
+}
+
+func TestInvalidRules(t *testing.T) {
+	_, err := New(config.Parsing{Rules: []config.Rule{{Name: "broken", Pattern: `(`}}})
+	assert.Error(t, err)
+
+	_, err = New(config.Parsing{Rules: []config.Rule{{Name: "no flat", Pattern: `\d+`}}})
+	assert.Error(t, err)
+
+	_, err = New(config.Parsing{FlatMin: 10, FlatMax: 5})
+	assert.Error(t, err)
+}
+
+func TestExpenses(t *testing.T) {
+	e, err := ExpensesFromConfig(&config.Config{Expenses: []config.ExpenseCategory{
+		{Name: "Repair", Pattern: `(?i)ремонт`},
+		{Name: "Hardware", MCCs: []int{5251}},
+	}})
+	require.NoError(t, err)
+
+	for expected, transaction := range map[string]api.Transaction{
+		"Repair":            {Description: "WOG", MCC: 5541, Comment: "ремонт генератора"},
+		"Hardware":          {Description: "Епіцентр", MCC: 5251},
+		"Fuel":              {Description: "ОККО", MCC: 5542},
+		"Generator service": {Description: "На банку", Comment: "ТО генератора"},
+		OtherCategory:       {Description: "На картку", MCC: 4829},
+	} {
+		assert.Equal(t, expected, e.Category(transaction), transaction)
+	}
+
+	_, err = NewExpenses([]config.ExpenseCategory{{Name: "broken", Pattern: `(`}})
+	assert.Error(t, err)
+}
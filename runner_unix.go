@@ -0,0 +1,55 @@
+//go:build unix
+
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+const defaultFilesDir = "/var/lib/diesgen"
+
+// isInteractive reports whether the process was started from a terminal
+// rather than by systemd, which always sets INVOCATION_ID for its units.
+func isInteractive() (bool, error) {
+	return os.Getenv("INVOCATION_ID") == "", nil
+}
+
+// runService runs s in the foreground translating SIGTERM and SIGINT into a stop
+// and SIGHUP into an immediate sync. Readiness is reported through sd_notify.
+func runService(_ string, s *DiesGenService, _ bool) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	commands := make(chan Command)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(commands, func(s State) {
+			err := sdNotify(notifyState(s))
+			if err != nil {
+				log.Errorf("sd_notify failed: %v", err)
+			}
+		})
+	}()
+
+	for {
+		select {
+		case <-done:
+			return nil
+		case sig := <-signals:
+			log.Infof("%s received", sig)
+			switch sig {
+			case syscall.SIGTERM, syscall.SIGINT:
+				commands <- CommandStop
+				<-done
+				return nil
+			case syscall.SIGHUP:
+				commands <- CommandSync
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/debug"
+	"io"
+)
+
+const defaultFilesDir = `C:\Users\alexm\Documents\private\logs\test`
+
+// windowsHandler adapts DiesGenService to the windows service control manager.
+type windowsHandler struct {
+	service *DiesGenService
+}
+
+func isInteractive() (bool, error) {
+	inService, err := svc.IsWindowsService()
+	if err != nil {
+		return false, err
+	}
+	return !inService, nil
+}
+
+func runService(name string, s *DiesGenService, interactive bool) error {
+	run := svc.Run
+	if interactive {
+		run = debug.Run
+	}
+	return run(name, &windowsHandler{service: s})
+}
+
+func writeSystemdUnit(_ io.Writer, _ unitParams) error {
+	return errors.New("systemd unit files are not supported on windows")
+}
+
+func (h *windowsHandler) Execute(_ []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (svcSpecificEC bool, errno uint32) {
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+
+	commands := make(chan Command)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.service.Run(commands, func(s State) {
+			switch s {
+			case StateStartPending:
+				changes <- svc.Status{State: svc.StartPending}
+			case StateRunning:
+				changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+			case StatePaused:
+				changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+			case StateStopPending:
+				// the webhook and admin servers get shutdownTimeout each, the canceled syncs stopTimeout
+				changes <- svc.Status{State: svc.StopPending, WaitHint: uint32((2*shutdownTimeout + stopTimeout).Milliseconds())}
+			case StateStopped:
+				changes <- svc.Status{State: svc.Stopped}
+			}
+		})
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case c := <-r:
+			switch c.Cmd {
+			case svc.Interrogate:
+				changes <- c.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Info("Stop or Shutdown received")
+				commands <- CommandStop
+				<-done
+				return
+			case svc.Pause:
+				commands <- CommandPause
+			case svc.Continue:
+				commands <- CommandContinue
+			default:
+				log.Errorf("unexpected control request: %v", c)
+			}
+		}
+	}
+}
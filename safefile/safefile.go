@@ -0,0 +1,139 @@
+// Package safefile replaces files atomically keeping rotated backups and serializes
+// writers of the same file with an advisory lock, so a crash mid-write or a concurrent
+// CLI command never leaves a truncated config or workbook behind.
+package safefile
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	lockSuffix   = ".lock"
+	lockInterval = 50 * time.Millisecond
+)
+
+// Backups is the number of rotated copies Save keeps next to the file, path.1 being the newest.
+var Backups = 3
+
+// LockTimeout is how long Lock waits for another process to release the file.
+var LockTimeout = 10 * time.Second
+
+// WriteFile replaces the file at path with data through a temporary file in the same
+// directory, readers see either the old or the new content.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() {
+		// no-op once renamed
+		_ = os.Remove(tmp)
+	}()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp, perm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("replace %s: %w", path, err)
+	}
+	return syncDir(dir)
+}
+
+// Save keeps Backups copies of the current file and replaces it with data.
+// Nothing is written when the content is unchanged.
+func Save(path string, data []byte, perm os.FileMode) error {
+	current, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if bytes.Equal(current, data) {
+			return nil
+		}
+		err = rotate(path, current, perm)
+		if err != nil {
+			return fmt.Errorf("backup %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	return WriteFile(path, data, perm)
+}
+
+// BackupPath returns the path of the n-th newest backup of path.
+func BackupPath(path string, n int) string {
+	return path + "." + strconv.Itoa(n)
+}
+
+func rotate(path string, current []byte, perm os.FileMode) error {
+	if Backups <= 0 {
+		return nil
+	}
+
+	for n := Backups - 1; n >= 1; n-- {
+		err := os.Rename(BackupPath(path, n), BackupPath(path, n+1))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return WriteFile(BackupPath(path, 1), current, perm)
+}
+
+// Locker is an exclusive advisory lock of a file held by Lock.
+type Locker struct {
+	f *os.File
+}
+
+// Lock takes the exclusive advisory lock of path waiting up to LockTimeout. The lock is
+// held on a separate path.lock file since renames replace the locked file itself.
+func Lock(path string) (*Locker, error) {
+	f, err := os.OpenFile(path+lockSuffix, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if locked {
+			return &Locker{f: f}, nil
+		}
+		if time.Now().After(deadline) {
+			_ = f.Close()
+			return nil, fmt.Errorf("lock %s: held by another process", path)
+		}
+		time.Sleep(lockInterval)
+	}
+}
+
+// Unlock releases the lock.
+func (l *Locker) Unlock() error {
+	err := unlock(l.f)
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,55 @@
+package safefile
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	for _, content := range []string{"1", "2", "3", "4", "4", "5"} {
+		require.NoError(t, Save(path, []byte(content), 0644))
+	}
+
+	read := func(path string) string {
+		b, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "5", read(path))
+	// the unchanged "4" is not backed up twice
+	assert.Equal(t, "4", read(BackupPath(path, 1)))
+	assert.Equal(t, "3", read(BackupPath(path, 2)))
+	assert.Equal(t, "2", read(BackupPath(path, 3)))
+	assert.NoFileExists(t, BackupPath(path, 4))
+
+	// no temporary files are left
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 4, len(entries))
+}
+
+func TestLock(t *testing.T) {
+	LockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() {
+		LockTimeout = 10 * time.Second
+	})
+	path := filepath.Join(t.TempDir(), "diesgen.xlsx")
+
+	l, err := Lock(path)
+	require.NoError(t, err)
+
+	_, err = Lock(path)
+	assert.ErrorContains(t, err, "held by another process")
+
+	require.NoError(t, l.Unlock())
+	l, err = Lock(path)
+	require.NoError(t, err)
+	require.NoError(t, l.Unlock())
+}
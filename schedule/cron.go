@@ -0,0 +1,154 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearch bounds the search for the next time, February 29 repeats at most every eight years.
+const cronSearch = 9
+
+// field is the set of values of a cron field.
+type field uint64
+
+func (f field) has(v int) bool {
+	return f&(1<<uint(v)) != 0
+}
+
+// cron is a parsed five field expression: minute, hour, day of month, month and day of week.
+type cron struct {
+	minute, hour, dom, month, dow field
+	// domAny and dowAny are set for "*" days, a day matches either restricted field otherwise
+	domAny, dowAny bool
+}
+
+func parseCron(expr string) (*cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	c := &cron{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	var errs []error
+	parse := func(dst *field, s string, min int, max int) {
+		f, err := parseField(s, min, max)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*dst = f
+	}
+	parse(&c.minute, fields[0], 0, 59)
+	parse(&c.hour, fields[1], 0, 23)
+	parse(&c.dom, fields[2], 1, 31)
+	parse(&c.month, fields[3], 1, 12)
+	parse(&c.dow, fields[4], 0, 7)
+	if err := errors.Join(errs...); err != nil {
+		return nil, fmt.Errorf("cron %q: %w", expr, err)
+	}
+
+	// 7 is sunday as well
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+	if c.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("cron %q: never matches", expr)
+	}
+	return c, nil
+}
+
+// parseField parses a comma separated list of *, values and ranges with an optional /step.
+func parseField(s string, min int, max int) (field, error) {
+	var f field
+	for _, part := range strings.Split(s, ",") {
+		r, step, hasStep := strings.Cut(part, "/")
+		first, last := min, max
+		switch {
+		case r == "*":
+		case strings.Contains(r, "-"):
+			a, b, _ := strings.Cut(r, "-")
+			var err error
+			if first, err = parseValue(a, min, max); err != nil {
+				return 0, err
+			}
+			if last, err = parseValue(b, min, max); err != nil {
+				return 0, err
+			}
+			if first > last {
+				return 0, fmt.Errorf("range %s is reversed", r)
+			}
+		default:
+			v, err := parseValue(r, min, max)
+			if err != nil {
+				return 0, err
+			}
+			first = v
+			if !hasStep {
+				last = v
+			}
+		}
+
+		n := 1
+		if hasStep {
+			var err error
+			n, err = strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", step)
+			}
+		}
+		for v := first; v <= last; v += n {
+			f |= 1 << uint(v)
+		}
+	}
+	return f, nil
+}
+
+func parseValue(s string, min int, max int) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("value %d is not within %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+func (c *cron) day(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first matching minute after t, zero when there is none within cronSearch years.
+func (c *cron) next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(cronSearch, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.day(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute.has(t.Minute()):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
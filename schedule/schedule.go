@@ -0,0 +1,201 @@
+// Package schedule decides when the service syncs: at an interval or the times of a cron
+// expression, outside the quiet hours and delayed by a random jitter.
+package schedule
+
+import (
+	"diesgen/config"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+const (
+	// quietSkips bounds the quiet hours a cron schedule skips looking for the next sync.
+	quietSkips = 100
+	// DefaultInterval is the interval of a config without a schedule.
+	DefaultInterval = time.Minute
+	// MinInterval keeps the syncs of a token within the client info rate limit of monobank.
+	MinInterval = time.Minute
+)
+
+// Schedule is a parsed config.Schedule.
+type Schedule struct {
+	interval time.Duration
+	cron     *cron
+	quiet    []window
+	jitter   time.Duration
+}
+
+// window is a daily time range in minutes since midnight, it wraps midnight when end is before start.
+type window struct {
+	start, end int
+}
+
+// Default returns the schedule of a config without one, every DefaultInterval.
+func Default() *Schedule {
+	return &Schedule{interval: DefaultInterval}
+}
+
+// FromConfig parses the schedule of the config, Default when it has none.
+func FromConfig(c *config.Config) (*Schedule, error) {
+	if c.Schedule == nil {
+		return Default(), nil
+	}
+	return New(*c.Schedule)
+}
+
+// New parses the schedule reporting every invalid setting.
+func New(cs config.Schedule) (*Schedule, error) {
+	s := Default()
+	var errs []error
+
+	switch {
+	case cs.Interval != "" && cs.Cron != "":
+		errs = append(errs, errors.New("schedule: interval and cron are exclusive"))
+	case cs.Cron != "":
+		c, err := parseCron(cs.Cron)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule: %w", err))
+		}
+		s.cron = c
+	case cs.Interval != "":
+		d, err := time.ParseDuration(cs.Interval)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("schedule interval: %w", err))
+		case d < MinInterval:
+			errs = append(errs, fmt.Errorf("schedule interval %s is shorter than %s", d, MinInterval))
+		}
+		s.interval = d
+	}
+
+	if cs.Jitter != "" {
+		d, err := time.ParseDuration(cs.Jitter)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("schedule jitter: %w", err))
+		case d < 0:
+			errs = append(errs, fmt.Errorf("schedule jitter %s is negative", d))
+		}
+		s.jitter = d
+	}
+
+	for _, q := range cs.QuietHours {
+		w, err := parseWindow(q)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("schedule quiet hours: %w", err))
+			continue
+		}
+		s.quiet = append(s.quiet, w)
+	}
+	if len(s.quiet) > 0 && len(s.quiet) == len(cs.QuietHours) {
+		switch {
+		case s.allQuiet():
+			errs = append(errs, errors.New("schedule: quiet hours cover the whole day"))
+		case s.cron != nil && s.Quiet(s.Next(time.Now())):
+			errs = append(errs, errors.New("schedule: every cron time is within quiet hours"))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseWindow parses "HH:MM-HH:MM".
+func parseWindow(s string) (window, error) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return window{}, fmt.Errorf("%q is not like 23:00-07:00", s)
+	}
+	start, err := time.Parse("15:04", a)
+	if err != nil {
+		return window{}, fmt.Errorf("%q: %w", s, err)
+	}
+	end, err := time.Parse("15:04", b)
+	if err != nil {
+		return window{}, fmt.Errorf("%q: %w", s, err)
+	}
+
+	w := window{start: start.Hour()*60 + start.Minute(), end: end.Hour()*60 + end.Minute()}
+	if w.start == w.end {
+		return window{}, fmt.Errorf("%q is empty", s)
+	}
+	return w, nil
+}
+
+func (w window) contains(minute int) bool {
+	if w.start < w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// endAfter returns the end of the window containing t.
+func (w window) endAfter(t time.Time) time.Time {
+	end := time.Date(t.Year(), t.Month(), t.Day(), w.end/60, w.end%60, 0, 0, t.Location())
+	if !end.After(t) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+func (s *Schedule) allQuiet() bool {
+	for m := 0; m < 24*60; m++ {
+		if !s.quietAt(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Schedule) quietAt(minute int) bool {
+	for _, w := range s.quiet {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// Quiet reports whether t is within the quiet hours.
+func (s *Schedule) Quiet(t time.Time) bool {
+	return s.quietAt(t.Hour()*60 + t.Minute())
+}
+
+// Next returns the first sync time after t outside the quiet hours, without the jitter. Syncs
+// falling into quiet hours are moved to their end, to the first cron time after it for cron schedules.
+func (s *Schedule) Next(t time.Time) time.Time {
+	next := s.after(t)
+	for i := 0; i < quietSkips && s.Quiet(next); i++ {
+		for _, w := range s.quiet {
+			if w.contains(next.Hour()*60 + next.Minute()) {
+				end := w.endAfter(next)
+				next = end
+				if s.cron != nil {
+					next = s.after(end.Add(-time.Nanosecond))
+				}
+				break
+			}
+		}
+	}
+	return next
+}
+
+func (s *Schedule) after(t time.Time) time.Time {
+	if s.cron != nil {
+		return s.cron.next(t)
+	}
+	return t.Add(s.interval)
+}
+
+// Jitter returns a random delay shorter than the configured jitter.
+func (s *Schedule) Jitter() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
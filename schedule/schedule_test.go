@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"diesgen/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func at(t *testing.T, s string) time.Time {
+	tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+	require.NoError(t, err)
+	return tm
+}
+
+func TestInterval(t *testing.T) {
+	s, err := FromConfig(&config.Config{})
+	require.NoError(t, err)
+	assert.Equal(t, at(t, "2024-07-01 10:01"), s.Next(at(t, "2024-07-01 10:00")))
+	assert.Zero(t, s.Jitter())
+
+	s, err = New(config.Schedule{Interval: "15m", QuietHours: []string{"23:00-07:00"}, Jitter: "30s"})
+	require.NoError(t, err)
+	assert.Equal(t, at(t, "2024-07-01 10:15"), s.Next(at(t, "2024-07-01 10:00")))
+	// moved to the end of the quiet hours
+	assert.Equal(t, at(t, "2024-07-02 07:00"), s.Next(at(t, "2024-07-01 22:50")))
+	assert.True(t, s.Quiet(at(t, "2024-07-02 03:00")))
+	assert.False(t, s.Quiet(at(t, "2024-07-02 07:00")))
+	for i := 0; i < 10; i++ {
+		j := s.Jitter()
+		assert.GreaterOrEqual(t, j, time.Duration(0))
+		assert.Less(t, j, 30*time.Second)
+	}
+}
+
+func TestCron(t *testing.T) {
+	s, err := New(config.Schedule{Cron: "*/20 8-18 * * 1-5", QuietHours: []string{"12:00-13:00"}})
+	require.NoError(t, err)
+
+	for from, expected := range map[string]string{
+		"2024-07-01 10:05": "2024-07-01 10:20",
+		"2024-07-01 10:40": "2024-07-01 11:00",
+		"2024-07-01 18:40": "2024-07-02 08:00",
+		// friday evening to monday
+		"2024-07-05 18:50": "2024-07-08 08:00",
+		// the lunch hour is quiet
+		"2024-07-01 11:45": "2024-07-01 13:00",
+	} {
+		assert.Equal(t, at(t, expected), s.Next(at(t, from)), from)
+	}
+
+	// either restricted day matches
+	s, err = New(config.Schedule{Cron: "30 6 1,15 * 0"})
+	require.NoError(t, err)
+	assert.Equal(t, at(t, "2024-07-07 06:30"), s.Next(at(t, "2024-07-02 00:00")))
+	assert.Equal(t, at(t, "2024-07-15 06:30"), s.Next(at(t, "2024-07-14 07:00")))
+
+	s, err = New(config.Schedule{Cron: "0 0 29 2 *"})
+	require.NoError(t, err)
+	assert.Equal(t, at(t, "2028-02-29 00:00"), s.Next(at(t, "2024-03-01 00:00")))
+}
+
+func TestInvalidSchedule(t *testing.T) {
+	for _, cs := range []config.Schedule{
+		{Interval: "5m", Cron: "* * * * *"},
+		{Interval: "10s"},
+		{Interval: "often"},
+		{Jitter: "-1s"},
+		{Cron: "* * * *"},
+		{Cron: "60 * * * *"},
+		{Cron: "5-1 * * * *"},
+		{Cron: "*/0 * * * *"},
+		{Cron: "0 0 30 2 *"},
+		{QuietHours: []string{"23:00"}},
+		{QuietHours: []string{"25:00-07:00"}},
+		{QuietHours: []string{"07:00-07:00"}},
+		{QuietHours: []string{"00:00-12:00", "12:00-00:00"}},
+		{Cron: "0 3 * * *", QuietHours: []string{"01:00-05:00"}},
+	} {
+		_, err := New(cs)
+		assert.Error(t, err, "%+v", cs)
+	}
+}
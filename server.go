@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout is how long a server waits for running requests on stop.
+const shutdownTimeout = 5 * time.Second
+
+// serve starts an http server for handler on addr, it returns nil when it can not listen.
+func serve(name string, addr string, handler http.Handler) *http.Server {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("%s server not started: %v", name, err)
+		return nil
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.Serve(ln)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("%s server failed: %v", name, err)
+		}
+	}()
+	log.Infof("%s server listening on %s", name, ln.Addr())
+	return server
+}
+
+// shutdown stops the server waiting shutdownTimeout for running requests, a nil server is ignored.
+func shutdown(server *http.Server) {
+	if server == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Errorf("server shutdown: %v", err)
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/logging"
+	"diesgen/store"
+	log "github.com/sirupsen/logrus"
+	"path/filepath"
+	"time"
+)
+
+// campaign is a configured campaign with the config it is processed with and its workbook.
+type campaign struct {
+	tenant string
+	id     string
+	config *config.Config
+	xlsx   string
+	log    *log.Entry
+}
+
+// campaigns returns the campaigns of the config logging to l with their name, workbook paths
+// are relative to the config.
+func campaigns(l *log.Entry, o Options, c *config.Config) []campaign {
+	var list []campaign
+	for _, cp := range c.CampaignList() {
+		xlsxPath := o.XlsxPath
+		if cp.Xlsx != "" {
+			xlsxPath = cp.Xlsx
+			if !filepath.IsAbs(xlsxPath) {
+				xlsxPath = filepath.Join(filepath.Dir(o.ConfigPath), xlsxPath)
+			}
+		}
+		list = append(list, campaign{tenant: o.Tenant, id: cp.ID(), config: c.For(cp), xlsx: xlsxPath,
+			log: l.WithField(logging.Campaign, cp.ID())})
+	}
+	return list
+}
+
+// jar returns the jar of the campaign selected by id or name.
+func (cp campaign) jar(jars []api.Jar) *api.Jar {
+	if cp.config.JarID != "" {
+		return api.GetJarByID(cp.config.JarID, jars)
+	}
+	return api.GetJar(cp.config.JarName, jars)
+}
+
+// records returns the stored records of the account made within the campaign dates.
+func (cp campaign) records(st *store.Store, account string) ([]store.Record, error) {
+	records, err := st.Records(account)
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := cp.period()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := records[:0]
+	for _, r := range records {
+		if within(r.Transaction, start, end) {
+			filtered = append(filtered, r)
+		}
+	}
+	return filtered, nil
+}
+
+// period returns the campaign dates, the end is zero for an open campaign.
+func (cp campaign) period() (time.Time, time.Time, error) {
+	start, err := cp.config.JarStartTime()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end, err := cp.config.JarEndTime()
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	return start, end, nil
+}
+
+func within(t api.Transaction, start time.Time, end time.Time) bool {
+	return t.Time >= start.Unix() && (end.IsZero() || t.Time <= end.Unix())
+}
@@ -0,0 +1,209 @@
+package service
+
+import (
+	"cmp"
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/logging"
+	"diesgen/money"
+	"diesgen/store"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Change is a payment a sync would add or attribute differently, Before is nil for new payments.
+type Change struct {
+	Before *store.Record
+	After  store.Record
+}
+
+// FlatChange is the income of a flat before and after a sync. New is set when the flat
+// gets its first row in the jar sheet.
+type FlatChange struct {
+	Flat   int
+	Before money.Money
+	After  money.Money
+	New    bool
+}
+
+// Diff is what a sync would change in the store, the workbook and the config for a campaign.
+type Diff struct {
+	Campaign   string
+	Changes    []Change
+	Flats      []FlatChange
+	Exclusions []config.Exclusion
+}
+
+// IsEmpty reports whether a sync would change nothing.
+func (d *Diff) IsEmpty() bool {
+	return len(d.Changes) == 0 && len(d.Flats) == 0 && len(d.Exclusions) == 0
+}
+
+// DryRun fetches the statements and attributes them with the stored history in memory.
+// Neither the store nor the workbooks nor the config are written.
+func DryRun(ctx context.Context, o Options) ([]*Diff, error) {
+	o.Timeouts = o.Timeouts.orDefault()
+	ctx, cancel := context.WithTimeout(ctx, o.Timeouts.Sync)
+	defer cancel()
+
+	ctx, l := withRun(ctx, o)
+	l.Infof("START dry run conf: %s, store: %s", o.ConfigPath, o.StorePath)
+
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the sync is simulated on a copy of the store
+	dir, err := os.MkdirTemp("", "diesgen-dry-run")
+	if err != nil {
+		return nil, err
+	}
+	defer func(dir string) {
+		_ = os.RemoveAll(dir)
+	}(dir)
+	st, err := store.Snapshot(o.StorePath, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	mono := NewClient(c, o.Timeouts.Request)
+	client, err := clientInfo(ctx, mono, c)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*Diff
+	var errs []error
+	for _, cp := range campaigns(l, o, c) {
+		d, err := dryRunCampaign(ctx, o, st, mono, client, cp)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("campaign %s: %w", cp.id, err))
+			continue
+		}
+		cp.log.Infof("%d changed transactions, %d new exclusions", len(d.Changes), len(d.Exclusions))
+		diffs = append(diffs, d)
+	}
+	l.Info("FINISH dry run")
+	return diffs, errors.Join(errs...)
+}
+
+func dryRunCampaign(ctx context.Context, o Options, st *store.Store, mono api.MonobankClient, client *api.Client, cp campaign) (*Diff, error) {
+	ctx, cancel := context.WithTimeout(logging.WithEntry(ctx, cp.log), o.Timeouts.Fetch)
+	defer cancel()
+	var s []api.Transaction
+	j, err := fetch(ctx, st, mono, client, cp, func(_ *api.Jar, _ time.Time, _ time.Time, window []api.Transaction) error {
+		s = append(s, window...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := cp.records(st, j.ID)
+	if err != nil {
+		return nil, err
+	}
+	l, err := newLearner(st, cp.log)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge the statement the way store.AddTransactions does
+	before := make(map[string]store.Record, len(stored))
+	index := make(map[string]int, len(stored))
+	records := make([]store.Record, 0, len(stored)+len(s))
+	for i, r := range stored {
+		before[r.Transaction.ID] = r
+		index[r.Transaction.ID] = i
+		records = append(records, r)
+	}
+	for _, t := range s {
+		if i, ok := index[t.ID]; ok {
+			records[i].Transaction = t
+			continue
+		}
+		index[t.ID] = len(records)
+		records = append(records, store.Record{Account: j.ID, Transaction: t, Source: store.SourceUnknown})
+	}
+	// attribute in the order of store.Records so payers are learned as on a sync
+	slices.SortStableFunc(records, func(a, b store.Record) int {
+		if c := cmp.Compare(a.Transaction.Time, b.Transaction.Time); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Transaction.ID, b.Transaction.ID)
+	})
+
+	_, unknown, err := attributeRecords(cp.config, records, l)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{Campaign: cp.id, Exclusions: unknown}
+	for _, r := range records {
+		b, ok := before[r.Transaction.ID]
+		switch {
+		case r.Transaction.Amount < 0:
+			// withdrawals are not attributed to flats
+		case !ok:
+			d.Changes = append(d.Changes, Change{After: r})
+		case b.Flat != r.Flat || b.Card != r.Card || b.Source != r.Source:
+			d.Changes = append(d.Changes, Change{Before: &b, After: r})
+		}
+	}
+	d.Flats, err = flatChanges(stored, records)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// flatChanges compares the income of every flat, only flats with a different income are returned.
+func flatChanges(before []store.Record, after []store.Record) ([]FlatChange, error) {
+	flats := make(map[int]*FlatChange)
+	flat := func(r store.Record) *FlatChange {
+		f, ok := flats[r.Flat]
+		if !ok {
+			f = &FlatChange{Flat: r.Flat, New: true}
+			flats[r.Flat] = f
+		}
+		return f
+	}
+
+	var err error
+	for _, r := range before {
+		if r.Transaction.Amount >= 0 {
+			f := flat(r)
+			f.New = false
+			if f.Before, err = f.Before.Add(r.Transaction.Money()); err != nil {
+				return nil, fmt.Errorf("flat %d: %w", r.Flat, err)
+			}
+		}
+	}
+	for _, r := range after {
+		if r.Transaction.Amount >= 0 {
+			f := flat(r)
+			if f.After, err = f.After.Add(r.Transaction.Money()); err != nil {
+				return nil, fmt.Errorf("flat %d: %w", r.Flat, err)
+			}
+		}
+	}
+
+	var changes []FlatChange
+	for _, f := range flats {
+		if f.Before.Amount != f.After.Amount {
+			changes = append(changes, *f)
+		}
+	}
+	slices.SortFunc(changes, func(a, b FlatChange) int {
+		return cmp.Compare(a.Flat, b.Flat)
+	})
+	return changes, nil
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: 20_050},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	// resolve the exclusion of b and receive two more payments
+	c, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c.Exclusions))
+	c.Exclusions[0].Flat = 12
+	require.NoError(t, config.SetConfig(o.ConfigPath, *c))
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "c", Time: time.Now().Add(-2 * time.Minute).Unix(), Comment: "кв 7", Amount: 10_000},
+		api.Transaction{ID: "d", Time: time.Now().Add(-time.Minute).Unix(), Amount: 5_000},
+		// withdrawals change no flat
+		api.Transaction{ID: "e", Time: time.Now().Add(-time.Minute).Unix(), Amount: -1_000},
+	)
+
+	configBefore, err := os.ReadFile(o.ConfigPath)
+	require.NoError(t, err)
+	xlsxBefore, err := os.ReadFile(o.XlsxPath)
+	require.NoError(t, err)
+	storeBefore, err := os.ReadFile(o.StorePath)
+	require.NoError(t, err)
+
+	diffs, err := DryRun(context.Background(), o)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(diffs))
+	d := diffs[0]
+
+	assert.Equal(t, "Diesel", d.Campaign)
+	require.Equal(t, 3, len(d.Changes))
+	assert.Equal(t, "b", d.Changes[0].After.Transaction.ID)
+	require.NotNil(t, d.Changes[0].Before)
+	assert.Equal(t, store.SourceUnknown, d.Changes[0].Before.Source)
+	assert.Equal(t, store.SourceExclusion, d.Changes[0].After.Source)
+	assert.Equal(t, 12, d.Changes[0].After.Flat)
+	assert.Nil(t, d.Changes[1].Before)
+	assert.Equal(t, 7, d.Changes[1].After.Flat)
+	assert.Nil(t, d.Changes[2].Before)
+	assert.Equal(t, store.SourceUnknown, d.Changes[2].After.Source)
+
+	assert.Equal(t, []FlatChange{
+		{Flat: 0, Before: money.New(20_050, money.UAH), After: money.New(5_000, money.UAH)},
+		{Flat: 7, After: money.New(10_000, money.UAH), New: true},
+		{Flat: 12, Before: money.New(50_000, money.UAH), After: money.New(70_050, money.UAH)},
+	}, d.Flats)
+
+	require.Equal(t, 1, len(d.Exclusions))
+	assert.Equal(t, "d", d.Exclusions[0].TransactionID)
+
+	// nothing is written
+	configAfter, err := os.ReadFile(o.ConfigPath)
+	require.NoError(t, err)
+	assert.Equal(t, configBefore, configAfter)
+	xlsxAfter, err := os.ReadFile(o.XlsxPath)
+	require.NoError(t, err)
+	assert.Equal(t, xlsxBefore, xlsxAfter)
+	storeAfter, err := os.ReadFile(o.StorePath)
+	require.NoError(t, err)
+	assert.Equal(t, storeBefore, storeAfter)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+	records, err := st.Records("jar1")
+	require.NoError(t, err)
+	require.Equal(t, 2, len(records))
+	assert.Equal(t, store.SourceUnknown, records[1].Source)
+}
+
+func TestDryRunWithoutStore(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: jarStartTime(t).Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	diffs, err := DryRun(context.Background(), o)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(diffs))
+	require.Equal(t, 1, len(diffs[0].Changes))
+	assert.Equal(t, 12, diffs[0].Changes[0].After.Flat)
+	assert.NoFileExists(t, o.StorePath)
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"diesgen/api"
+	"diesgen/exel"
+	"diesgen/logging"
+	"diesgen/store"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// learner remembers which flat a payer IBAN or name pays for and attributes
+// their later transactions without a usable comment.
+type learner struct {
+	known   map[string]store.Counterparty
+	changed map[string]bool
+	log     *log.Entry
+}
+
+func newLearner(st *store.Store, entry *log.Entry) (*learner, error) {
+	counterparties, err := st.Counterparties()
+	if err != nil {
+		return nil, err
+	}
+
+	l := &learner{
+		known:   make(map[string]store.Counterparty, len(counterparties)),
+		changed: make(map[string]bool),
+		log:     entry,
+	}
+	for _, c := range counterparties {
+		l.known[c.Key] = c
+	}
+	return l, nil
+}
+
+func counterpartyKeys(t api.Transaction) []string {
+	var keys []string
+	if t.CounterIban != "" {
+		keys = append(keys, store.IBANKey(t.CounterIban))
+	}
+	if t.CounterName != "" {
+		keys = append(keys, store.NameKey(t.CounterName))
+	}
+	return keys
+}
+
+// resolve is an exel.Resolver attributing by the payer IBAN first and the name then.
+func (l *learner) resolve(t api.Transaction) (*exel.FlatAndCard, store.Source, bool) {
+	for _, key := range counterpartyKeys(t) {
+		c, ok := l.known[key]
+		if !ok || c.Ambiguous || c.Forgotten() {
+			continue
+		}
+		return &exel.FlatAndCard{Flat: c.Flat}, store.SourceLearned, true
+	}
+	return nil, "", false
+}
+
+// learn records the flat a transaction was resolved to by the config.
+// Payers resolved to different flats become ambiguous and are not used anymore,
+// forgotten ones are learned from transactions after they were forgotten only.
+func (l *learner) learn(t api.Transaction, flat int, source store.Source) {
+	if flat == 0 || source == store.SourceLearned || source == store.SourceUnknown {
+		return
+	}
+
+	for _, key := range counterpartyKeys(t) {
+		c, ok := l.known[key]
+		switch {
+		case ok && time.Unix(t.Time, 0).Before(c.Since):
+			continue
+		case !ok || c.Forgotten():
+			c = store.Counterparty{Key: key, Flat: flat, Since: c.Since}
+			l.log.WithFields(log.Fields{logging.Flat: flat, logging.Transaction: t.ID}).
+				Infof("learned %s pays for flat %d", key, flat)
+		case c.Ambiguous || c.Flat == flat:
+			continue
+		case c.TransactionID == t.ID:
+			// the transaction it was learned from is attributed to another flat now
+			c.Flat = flat
+		default:
+			l.log.WithFields(log.Fields{logging.Flat: flat, logging.Transaction: t.ID}).
+				Warnf("%s pays for flats %d and %d, not using it for attribution", key, c.Flat, flat)
+			c.Ambiguous = true
+		}
+		c.TransactionID = t.ID
+		c.UpdatedAt = time.Now()
+		l.known[key] = c
+		l.changed[key] = true
+	}
+}
+
+func (l *learner) save(st *store.Store) error {
+	var changed []store.Counterparty
+	for key := range l.changed {
+		changed = append(changed, l.known[key])
+	}
+	return st.PutCounterparties(changed...)
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"diesgen/config"
+	"diesgen/metrics"
+	"diesgen/money"
+	"diesgen/store"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	syncsTotal = metrics.Default.NewCounter("diesgen_syncs_total",
+		"Syncs by result.", "tenant", "result")
+	lastSuccess = metrics.Default.NewGauge("diesgen_last_successful_sync_timestamp_seconds",
+		"Unix time of the last sync without errors.", "tenant")
+	syncDuration = metrics.Default.NewGauge("diesgen_sync_duration_seconds",
+		"Duration of the last sync.", "tenant")
+	apiRequests = metrics.Default.NewCounter("diesgen_api_requests_total",
+		"Monobank api requests by endpoint and response status, error when no response was received.", "endpoint", "status")
+	transactionsProcessed = metrics.Default.NewCounter("diesgen_transactions_processed_total",
+		"New transactions stored from statements and webhook pushes.", "tenant", "campaign")
+	unknownExclusions = metrics.Default.NewGauge("diesgen_unknown_exclusions",
+		"Exclusions waiting for a flat.", "tenant")
+	collected = metrics.Default.NewGauge("diesgen_collected",
+		"Income of the campaign in major currency units.", "tenant", "campaign", "currency")
+	jarBalance = metrics.Default.NewGauge("diesgen_jar_balance",
+		"Balance of the campaign jar reported by monobank in major currency units.", "tenant", "campaign", "currency")
+)
+
+// instrumentedTransport counts the monobank api requests.
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (t instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	apiRequests.Inc(endpoint(req.URL.Path), status)
+	return resp, err
+}
+
+// endpoint returns the api endpoint of the path without the account and period of statements.
+func endpoint(path string) string {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) < 2 {
+		return path
+	}
+	return parts[1]
+}
+
+func observeSync(tenant string, began time.Time, err error) {
+	syncDuration.Set(time.Since(began).Seconds(), tenant)
+	if err != nil {
+		syncsTotal.Inc(tenant, "error")
+		return
+	}
+	syncsTotal.Inc(tenant, "success")
+	lastSuccess.Set(float64(time.Now().Unix()), tenant)
+}
+
+// ForgetCampaigns drops the gauges of the campaigns of prev the reloaded config next has no more,
+// so removed and renamed campaigns are not reported with their last values.
+func ForgetCampaigns(tenant string, prev *config.Config, next *config.Config) {
+	kept := make(map[string]bool)
+	for _, cp := range next.CampaignList() {
+		kept[cp.ID()] = true
+	}
+	for _, cp := range prev.CampaignList() {
+		if !kept[cp.ID()] {
+			collected.Delete(tenant, cp.ID())
+			jarBalance.Delete(tenant, cp.ID())
+		}
+	}
+}
+
+// observeCollected sets the income of the campaign from its records.
+func observeCollected(cp campaign, records []store.Record) {
+	income := make(map[int]int64)
+	for _, r := range records {
+		if m := r.Transaction.Money(); m.Amount > 0 {
+			income[m.Currency] += m.Amount
+		}
+	}
+	for currency, amount := range income {
+		m := money.New(amount, currency)
+		collected.Set(m.Float(), cp.tenant, cp.id, m.CurrencySymbol())
+	}
+}
+
+// observeExclusions counts the exclusions without a flat whose transaction was not attributed otherwise meanwhile.
+func observeExclusions(tenant string, configPath string, st *store.Store) error {
+	c, err := config.GetConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	for _, e := range c.Exclusions {
+		if e.Flat != 0 {
+			continue
+		}
+		r, err := st.Find(e.TransactionID)
+		if err != nil {
+			return err
+		}
+		if r == nil || r.Source == store.SourceUnknown {
+			n++
+		}
+	}
+	unknownExclusions.Set(float64(n), tenant)
+	return nil
+}
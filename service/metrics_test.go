@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessMetrics(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddJar(api.Jar{ID: "jar4", Title: "Metrics", CurrencyCode: 980, Balance: 70_000})
+	server.AddTransactions("jar4",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000, Balance: 50_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: 20_000, Balance: 70_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		Tenant:     "metrics",
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Metrics",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, metrics.Default.Write(&b))
+	for _, line := range []string{
+		`diesgen_syncs_total{tenant="metrics",result="success"} 1`,
+		`diesgen_transactions_processed_total{tenant="metrics",campaign="Metrics"} 2`,
+		`diesgen_unknown_exclusions{tenant="metrics"} 1`,
+		`diesgen_collected{tenant="metrics",campaign="Metrics",currency="UAH"} 700`,
+		`diesgen_jar_balance{tenant="metrics",campaign="Metrics",currency="UAH"} 700`,
+	} {
+		assert.Contains(t, b.String(), line+"\n")
+	}
+	assert.Contains(t, b.String(), `diesgen_api_requests_total{endpoint="statement",status="200"}`)
+	assert.Contains(t, b.String(), `diesgen_last_successful_sync_timestamp_seconds{tenant="metrics"}`)
+}
+
+func TestForgetCampaigns(t *testing.T) {
+	for _, id := range []string{"Diesel", "Repair"} {
+		collected.Set(100, "forget", id, "UAH")
+		jarBalance.Set(100, "forget", id, "UAH")
+	}
+	prev := &config.Config{Campaigns: []config.Campaign{{JarName: "Diesel"}, {JarName: "Repair"}}}
+	ForgetCampaigns("forget", prev, &config.Config{Campaigns: []config.Campaign{{JarName: "Diesel"}}})
+
+	var b strings.Builder
+	require.NoError(t, metrics.Default.Write(&b))
+	assert.Contains(t, b.String(), `diesgen_collected{tenant="forget",campaign="Diesel",currency="UAH"} 100`)
+	assert.Contains(t, b.String(), `diesgen_jar_balance{tenant="forget",campaign="Diesel",currency="UAH"} 100`)
+	assert.NotContains(t, b.String(), `tenant="forget",campaign="Repair"`)
+}
+
+func TestEndpoint(t *testing.T) {
+	assert.Equal(t, "statement", endpoint("/personal/statement/jar1/1719302400/1719388800"))
+	assert.Equal(t, "client-info", endpoint("/personal/client-info"))
+	assert.Equal(t, "/", endpoint("/"))
+}
@@ -1,81 +1,523 @@
 package service
 
 import (
+	"context"
+	"crypto/sha256"
 	"diesgen/api"
 	"diesgen/config"
 	"diesgen/exel"
+	"diesgen/logging"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/safefile"
+	"diesgen/store"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/tealeg/xlsx"
+	"net/http"
 	"os"
 	"slices"
+	"time"
 )
 
-func Process(configPath string, xlsxFile string) {
-	log.Infof("START processing conf: %s, xlsx: %s", configPath, xlsxFile)
+const (
+	// refetchOverlap is fetched again before the last stored transaction in case the bank
+	// adds transactions with an earlier time late
+	refetchOverlap = time.Hour
+)
+
+// limiter is shared by every Process call so the bank limits hold across service ticks.
+var limiter = api.NewRateLimiter(api.RequestInterval)
+
+// NewClient returns the monobank client used for the config, every request is bounded by timeout.
+func NewClient(c *config.Config, timeout time.Duration) api.MonobankClient {
+	return api.NewMonobank(c.APIBaseURL, c.XToken, &http.Client{
+		Timeout:   timeout,
+		Transport: instrumentedTransport{next: http.DefaultTransport},
+	})
+}
+
+// Timeouts bound the stages of a sync, a zero field is replaced with the one of DefaultTimeouts.
+type Timeouts struct {
+	// Request bounds a single monobank api request
+	Request time.Duration
+	// Fetch bounds fetching the statement of a campaign including the rate limit waits
+	Fetch time.Duration
+	// Write bounds attributing a campaign and writing its workbook
+	Write time.Duration
+	// Sync bounds a whole sync of every campaign
+	Sync time.Duration
+}
+
+// DefaultTimeouts leave room for fetching a year of history of a new campaign at one request a minute.
+var DefaultTimeouts = Timeouts{Request: 30 * time.Second, Fetch: 30 * time.Minute, Write: 2 * time.Minute, Sync: time.Hour}
+
+func (t Timeouts) orDefault() Timeouts {
+	pick := func(d time.Duration, def time.Duration) time.Duration {
+		if d > 0 {
+			return d
+		}
+		return def
+	}
+	return Timeouts{
+		Request: pick(t.Request, DefaultTimeouts.Request),
+		Fetch:   pick(t.Fetch, DefaultTimeouts.Fetch),
+		Write:   pick(t.Write, DefaultTimeouts.Write),
+		Sync:    pick(t.Sync, DefaultTimeouts.Sync),
+	}
+}
+
+// Options locate the files of a diesgen instance.
+type Options struct {
+	// Tenant names the building of a multi-tenant instance, log entries are tagged with it
+	Tenant     string
+	ConfigPath string
+	XlsxPath   string
+	StorePath  string
+	// Webhook registers the webhookUrl of the config with monobank, it is set while the receiver runs
+	Webhook  bool
+	Timeouts Timeouts
+	// Authorized is called when the bank accepted the token, before the campaigns are synced
+	Authorized func()
+}
+
+// Logger returns the log entry of the instance.
+func (o Options) Logger() *log.Entry {
+	entry := log.NewEntry(log.StandardLogger())
+	if o.Tenant != "" {
+		entry = entry.WithField(logging.Tenant, o.Tenant)
+	}
+	return entry
+}
+
+// withRun returns the log entry of a new run of the instance and ctx carrying it to the api and exel.
+func withRun(ctx context.Context, o Options) (context.Context, *log.Entry) {
+	l := o.Logger().WithField(logging.Run, logging.NewRunID())
+	return logging.WithEntry(ctx, l), l
+}
 
-	c, err := config.GetConfig(configPath)
+// limiterKey returns the rate limiter key of the requests of kind made with the token, monobank
+// limits every token on its own. The token is hashed to keep it out of the logs.
+func limiterKey(kind string, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return kind + "/" + hex.EncodeToString(sum[:4])
+}
+
+// Process syncs every campaign of the config: it fetches the new transactions of the jar,
+// attributes them, reconciles the jar balance and regenerates the workbook. Requests and
+// waits for the rate limits are abandoned when ctx is done, workbooks are not replaced then.
+func Process(ctx context.Context, o Options) error {
+	o.Timeouts = o.Timeouts.orDefault()
+	ctx, cancel := context.WithTimeout(ctx, o.Timeouts.Sync)
+	defer cancel()
+
+	began := time.Now()
+	err := process(ctx, o)
+	observeSync(o.Tenant, began, err)
+	return err
+}
+
+func process(ctx context.Context, o Options) error {
+	ctx, l := withRun(ctx, o)
+	l.Infof("START processing conf: %s, xlsx: %s, store: %s", o.ConfigPath, o.XlsxPath, o.StorePath)
+
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	mono := NewClient(c, o.Timeouts.Request)
+	client, err := clientInfo(ctx, mono, c)
 	if err != nil {
-		log.Error(err)
-		return
+		return err
+	}
+	if o.Authorized != nil {
+		o.Authorized()
+	}
+
+	if o.Webhook && c.WebhookURL != "" && client.WebHookUrl != c.WebhookURL {
+		err = mono.SetWebHook(ctx, c.WebhookURL)
+		if err != nil {
+			// polling goes on
+			l.Warnf("webhook %s not registered: %v", c.WebhookURL, err)
+		} else {
+			l.Infof("webhook %s registered", c.WebhookURL)
+		}
 	}
 
-	client, err := api.GetClient(c.XToken)
+	var errs []error
+	var unknown []config.Exclusion
+	for _, cp := range campaigns(l, o, c) {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		exclusions, err := processCampaign(ctx, o, st, mono, client, cp)
+		unknown = append(unknown, exclusions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("campaign %s: %w", cp.id, err))
+		}
+	}
+	// a single write rotates a single backup of the config
+	err = config.AddExclusions(o.ConfigPath, unknown...)
 	if err != nil {
-		log.Error(err)
-		return
+		errs = append(errs, err)
 	}
 
-	j := api.GetJar(c.JarName, client.Jars)
+	err = observeExclusions(o.Tenant, o.ConfigPath, st)
+	if err != nil {
+		l.Warnf("unknown exclusions not counted: %v", err)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	l.Infof("FINISH processing conf: %s, xlsx: %s", o.ConfigPath, o.XlsxPath)
+	return nil
+}
+
+// processCampaign syncs the campaign and returns the exclusions to add for transactions nothing could attribute.
+func processCampaign(ctx context.Context, o Options, st *store.Store, mono api.MonobankClient, client *api.Client, cp campaign) ([]config.Exclusion, error) {
+	ctx = logging.WithEntry(ctx, cp.log)
+	fetchCtx, cancel := context.WithTimeout(ctx, o.Timeouts.Fetch)
+	var added int
+	// every window is stored as it completes, a failed fetch resumes after the last one
+	j, err := fetch(fetchCtx, st, mono, client, cp, func(j *api.Jar, from time.Time, to time.Time, s []api.Transaction) error {
+		n, err := st.AddTransactions(j.ID, s)
+		if err != nil {
+			return err
+		}
+		added += n
+		dropped, err := st.DropPushed(j.ID, from, to)
+		if err != nil {
+			return err
+		}
+		for _, r := range dropped {
+			cp.log.WithFields(log.Fields{
+				logging.Transaction: r.Transaction.ID,
+				logging.Amount:      r.Transaction.Money().String(),
+			}).Warn("pushed transaction is not in the statement, dropped")
+		}
+		return st.SetJarState(cp.id, store.JarState{Account: j.ID, FetchedTo: to})
+	})
+	cancel()
+	cp.log.Infof("%d new transactions", added)
+	transactionsProcessed.Add(float64(added), cp.tenant, cp.id)
+	if err != nil {
+		return nil, err
+	}
+	balance := money.New(int64(j.Balance), j.CurrencyCode)
+	jarBalance.Set(balance.Float(), cp.tenant, cp.id, balance.CurrencySymbol())
+
+	writeCtx, cancel := context.WithTimeout(ctx, o.Timeouts.Write)
+	defer cancel()
+	unknown, err := attribute(st, cp, j.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = reconcile(st, cp, j, time.Now())
+	if err != nil {
+		return unknown, err
+	}
+
+	return unknown, rebuild(writeCtx, st, cp, j.ID)
+}
+
+// clientInfo returns the jars of the token respecting the client info rate limit.
+func clientInfo(ctx context.Context, mono api.MonobankClient, c *config.Config) (*api.Client, error) {
+	clientInfoKey := limiterKey("client-info", c.XToken)
+	err := limiter.Wait(ctx, clientInfoKey)
+	if err != nil {
+		return nil, fmt.Errorf("client info: %w", err)
+	}
+	client, err := mono.ClientInfo(ctx)
+	if err != nil {
+		var apiErr *api.Error
+		if errors.As(err, &apiErr) && errors.Is(apiErr, api.ErrRateLimited) {
+			limiter.Delay(clientInfoKey, apiErr.RetryAfter)
+		}
+		return nil, fmt.Errorf("client info: %w", err)
+	}
+	return client, nil
+}
+
+// fetch looks the jar of the campaign up and fetches its statement since the last fetched period
+// up to now or the campaign end. It calls save with every fetched window from the oldest, the
+// range of the window and its transactions.
+func fetch(ctx context.Context, st *store.Store, mono api.MonobankClient, client *api.Client, cp campaign,
+	save func(j *api.Jar, from time.Time, to time.Time, s []api.Transaction) error) (*api.Jar, error) {
+	j := cp.jar(client.Jars)
 	if j == nil {
-		log.Error("jar not found")
-		return
+		return nil, errors.New("jar not found")
 	}
 
-	s, err := api.GetStatementFromToNow(c.XToken, *j, c.JarStart)
+	state, err := st.JarState(cp.id)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, err
 	}
 
-	// remove withdrawals
-	s = slices.DeleteFunc(s, func(transaction api.Transaction) bool {
-		return transaction.Amount < 0
+	to := time.Now()
+	end, err := cp.config.JarEndTime()
+	if err != nil {
+		return nil, err
+	}
+	if !end.IsZero() && end.Before(to) {
+		to = end
+		if state.Account == j.ID && !state.FetchedTo.Before(end) {
+			// the closed campaign is fetched completely
+			return j, nil
+		}
+	}
+
+	from, err := fetchFrom(cp.config, st, state, j.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	fetcher := &api.StatementFetcher{Client: mono, Limiter: limiter, Key: limiterKey("statement", cp.config.XToken)}
+	err = fetcher.FetchWindows(ctx, j.ID, from, to, func(windowFrom time.Time, windowTo time.Time, s []api.Transaction) error {
+		return save(j, windowFrom, windowTo, s)
 	})
+	if err != nil {
+		return nil, fmt.Errorf("statement: %w", err)
+	}
+	return j, nil
+}
+
+// fetchFrom returns the jar start for a campaign synced for the first time and the end of the last
+// fetched period otherwise, history before it is already in the store. Stores without the fetched
+// period fall back to the time of the last stored transaction.
+func fetchFrom(c *config.Config, st *store.Store, state store.JarState, account string) (time.Time, error) {
+	start, err := c.JarStartTime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if state.Account != account {
+		return start, nil
+	}
+
+	// transactions pushed by the webhook after the fetched period do not count,
+	// transactions before them may be missing
+	last := state.FetchedTo
+	if last.IsZero() {
+		last, err = st.LastTime(account)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if from := last.Add(-refetchOverlap); from.After(start) {
+		return from, nil
+	}
+	return start, nil
+}
+
+// ErrNotSynced is returned for campaigns whose jar was never fetched.
+var ErrNotSynced = errors.New("jar is not synced yet")
+
+// RebuildWorkbook attributes the stored transactions of every campaign again and regenerates
+// the workbooks without asking the bank.
+func RebuildWorkbook(ctx context.Context, o Options) error {
+	ctx, l := withRun(ctx, o)
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	var errs []error
+	var unknown []config.Exclusion
+	for _, cp := range campaigns(l, o, c) {
+		exclusions, err := rebuildCampaign(logging.WithEntry(ctx, cp.log), st, cp)
+		unknown = append(unknown, exclusions...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("campaign %s: %w", cp.id, err))
+		}
+	}
+	err = config.AddExclusions(o.ConfigPath, unknown...)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func rebuildCampaign(ctx context.Context, st *store.Store, cp campaign) ([]config.Exclusion, error) {
+	account, err := jarAccount(st, cp)
+	if err != nil {
+		return nil, err
+	}
+
+	unknown, err := attribute(st, cp, account)
+	if err != nil {
+		return nil, err
+	}
+	return unknown, rebuild(ctx, st, cp, account)
+}
+
+func jarAccount(st *store.Store, cp campaign) (string, error) {
+	state, err := st.JarState(cp.id)
+	if err != nil {
+		return "", err
+	}
+	if state.Account == "" {
+		return "", ErrNotSynced
+	}
+	return state.Account, nil
+}
+
+// rebuild regenerates the sheets of the campaign from the store, the workbook is kept when ctx is done.
+func rebuild(ctx context.Context, st *store.Store, cp campaign, account string) error {
+	records, err := cp.records(st, account)
+	if err != nil {
+		return err
+	}
+	observeCollected(cp, records)
+	counterparties, err := st.Counterparties()
+	if err != nil {
+		return err
+	}
+	reconciliations, err := st.Reconciliations(account)
+	if err != nil {
+		return err
+	}
 
-	file, err := xlsx.OpenFile(xlsxFile)
+	l, err := safefile.Lock(cp.xlsx)
+	if err != nil {
+		return err
+	}
+	defer func(l *safefile.Locker) {
+		_ = l.Unlock()
+	}(l)
+
+	file, err := xlsx.OpenFile(cp.xlsx)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			log.Infof("xlsx file not exist, creating %s", xlsxFile)
+			cp.log.Infof("xlsx file not exist, creating %s", cp.xlsx)
 			file = xlsx.NewFile()
 		} else {
-			log.Error(err)
-			return
+			return err
 		}
 	}
 
-	err = exel.ProcessStatement(file, s, configPath)
+	err = exel.WriteSheet(ctx, file, records, cp.config)
+	if err != nil {
+		return err
+	}
+
+	err = exel.WriteBalanceSheet(ctx, file, records, cp.config, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = exel.WriteExpensesSheet(ctx, file, records, cp.config)
+	if err != nil {
+		return err
+	}
+
+	err = exel.WriteSummarySheet(ctx, file, records, cp.config, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = exel.WriteReconciliationSheet(ctx, file, reconciliations, cp.config)
 	if err != nil {
-		log.Error(err)
-		return
+		return err
 	}
 
-	err = exel.SortMainTable(file, configPath)
+	err = exel.WriteCounterpartiesSheet(ctx, file, counterparties)
+	if err != nil {
+		return err
+	}
+
+	return exel.Save(ctx, file, cp.xlsx)
+}
+
+// attribute resolves the flat of every stored income transaction of the campaign so
+// edited exclusions and learned payers apply to history. It returns the exclusions to add to the config.
+func attribute(st *store.Store, cp campaign, account string) ([]config.Exclusion, error) {
+	records, err := cp.records(st, account)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, err
 	}
 
-	err = exel.CleanZeroAmountValues(file, configPath)
+	l, err := newLearner(st, cp.log)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, err
 	}
 
-	err = file.Save(xlsxFile)
+	changed, unknown, err := attributeRecords(cp.config, records, l)
 	if err != nil {
-		log.Error(err)
-		return
+		return nil, err
+	}
+
+	for _, e := range unknown {
+		cp.log.WithFields(log.Fields{
+			logging.Transaction: e.TransactionID,
+			logging.Amount:      e.Amount.String(),
+			"comment":           e.Comment,
+		}).Error("invalid comment")
+	}
+
+	if len(changed) > 0 {
+		cp.log.Infof("%d transactions attributed", len(changed))
+	}
+	err = st.Put(changed...)
+	if err != nil {
+		return nil, err
+	}
+	return unknown, l.save(st)
+}
+
+// attributeRecords resolves the flat of every income record in place without changing the config.
+// It returns the changed records and the exclusions to add for transactions nothing could attribute.
+func attributeRecords(c *config.Config, records []store.Record, l *learner) ([]store.Record, []config.Exclusion, error) {
+	engine, err := rules.FromConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var changed []store.Record
+	var unknown []config.Exclusion
+	for i, r := range records {
+		// withdrawals are not attributed to flats
+		if r.Transaction.Amount < 0 {
+			continue
+		}
+
+		pair, source := exel.Resolve(c, engine, r.Transaction, l.resolve)
+		l.learn(r.Transaction, pair.Flat, source)
+
+		if source == store.SourceUnknown && !slices.ContainsFunc(c.Exclusions, func(e config.Exclusion) bool {
+			return e.TransactionID == r.Transaction.ID
+		}) {
+			unknown = append(unknown, exel.UnknownExclusion(r.Transaction))
+		}
+
+		if pair.Flat == r.Flat && pair.Card == r.Card && source == r.Source && pair.Rule == r.Rule {
+			continue
+		}
+
+		records[i].Flat = pair.Flat
+		records[i].Card = pair.Card
+		records[i].Source = source
+		records[i].Rule = pair.Rule
+		changed = append(changed, records[i])
 	}
-	log.Infof("FINISH processing conf: %s, xlsx: %s", configPath, xlsxFile)
+	return changed, unknown, nil
 }
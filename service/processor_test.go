@@ -0,0 +1,737 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/api/fake"
+	"diesgen/config"
+	"diesgen/logging"
+	"diesgen/money"
+	"diesgen/safefile"
+	"diesgen/store"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tealeg/xlsx"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testToken    = "token"
+	testJarStart = "2024-06-25 11:00:00 +0300 EEST"
+)
+
+func newTestServer(t *testing.T) *fake.Server {
+	limiter = api.NewRateLimiter(0)
+
+	server := fake.NewServer(testToken)
+	t.Cleanup(server.Close)
+
+	server.AddJar(api.Jar{ID: "jar1", Title: "Diesel", CurrencyCode: 980})
+	server.AddJar(api.Jar{ID: "jar2", Title: "Starlink", CurrencyCode: 980})
+	return server
+}
+
+func jarStartTime(t *testing.T) time.Time {
+	start, err := time.Parse("2006-01-02 15:04:05 -0700 MST", testJarStart)
+	require.NoError(t, err)
+	return start
+}
+
+func TestProcess(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Comment: "кв 7", Amount: 20_000},
+		api.Transaction{ID: "c", Time: start.Add(3 * time.Hour).Unix(), Comment: "12", Amount: 10_000},
+		api.Transaction{ID: "d", Time: start.Add(4 * time.Hour).Unix(), Amount: -30_000},
+		// before the jar start
+		api.Transaction{ID: "e", Time: start.Add(-time.Hour).Unix(), Comment: "3", Amount: 10_000},
+	)
+	server.AddTransactions("jar2",
+		api.Transaction{ID: "f", Time: start.Add(time.Hour).Unix(), Comment: "1", Amount: 10_000},
+	)
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "config.json")
+	xlsxPath := filepath.Join(dir, "diesgen.xlsx")
+	o := Options{ConfigPath: confPath, XlsxPath: xlsxPath, StorePath: filepath.Join(dir, "diesgen.db")}
+
+	err := config.SetConfig(confPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	// the second run must not duplicate transactions
+	for i := 0; i < 2; i++ {
+		err = Process(context.Background(), o)
+		require.NoError(t, err)
+	}
+	assertWorkbook(t, xlsxPath)
+	assert.Equal(t, 2, server.Requests("/personal/client-info"))
+
+	// the workbook is rebuilt from the store
+	require.NoError(t, os.Remove(xlsxPath))
+	err = RebuildWorkbook(context.Background(), o)
+	require.NoError(t, err)
+	assertWorkbook(t, xlsxPath)
+	assert.Equal(t, 2, server.Requests("/personal/client-info"))
+
+	reports, err := BuildReport(o)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reports))
+	r := reports[0]
+	assert.Equal(t, "Diesel", r.Campaign)
+	assert.Equal(t, 4, r.Transactions)
+	assert.Equal(t, money.New(80_000, money.UAH), r.Income)
+	assert.Equal(t, money.New(30_000, money.UAH), r.Withdrawn)
+	assert.Equal(t, []FlatTotal{
+		{Flat: 7, Transactions: 1, Paid: money.New(20_000, money.UAH)},
+		{Flat: 12, Transactions: 2, Paid: money.New(60_000, money.UAH)},
+	}, r.Flats)
+	assert.Equal(t, []CategoryTotal{
+		{Category: "Other", Transactions: 1, Spent: money.New(30_000, money.UAH)},
+	}, r.Expenses)
+}
+
+func TestProcessKeepsUnchangedWorkbook(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_050},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: -30_000, MCC: 5541},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = Process(context.Background(), o)
+		require.NoError(t, err)
+	}
+	// nothing changed, the backups keep older versions
+	assert.NoFileExists(t, safefile.BackupPath(o.XlsxPath, 1))
+
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "c", Time: time.Now().Add(-time.Minute).Unix(), Comment: "7", Amount: 10_000},
+	)
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+	assert.FileExists(t, safefile.BackupPath(o.XlsxPath, 1))
+	assert.NoFileExists(t, safefile.BackupPath(o.XlsxPath, 2))
+}
+
+func TestProcessResumesFetch(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+		api.Transaction{ID: "b", Time: start.Add(70 * 24 * time.Hour).Unix(), Comment: "7", Amount: 20_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	// the second statement window fails
+	firstWindow := fmt.Sprintf("/personal/statement/jar1/%d/", start.Unix())
+	secondWindow := fmt.Sprintf("/personal/statement/jar1/%d/", start.Add(api.MaxStatementPeriod).Unix())
+	server.Fail(secondWindow, http.StatusBadRequest, "statement failed", 1)
+	err = Process(context.Background(), o)
+	require.Error(t, err)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	_, err = st.Get("jar1", "a")
+	require.NoError(t, err)
+	state, err := st.JarState("Diesel")
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(api.MaxStatementPeriod-time.Second).Unix(), state.FetchedTo.Unix())
+	require.NoError(t, st.Close())
+
+	// the next sync goes on after the stored window
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.Requests(firstWindow))
+	resumed := fmt.Sprintf("/personal/statement/jar1/%d/", state.FetchedTo.Add(-refetchOverlap).Unix())
+	assert.Equal(t, 1, server.Requests(resumed))
+
+	file, err := xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	assert.Equal(t, 3, len(file.Sheet["2024-06-25"].Rows))
+}
+
+func TestRebuildWorkbookNotSynced(t *testing.T) {
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{XToken: testToken, JarName: "Diesel", JarStart: testJarStart})
+	require.NoError(t, err)
+
+	err = RebuildWorkbook(context.Background(), o)
+	assert.ErrorIs(t, err, ErrNotSynced)
+	assert.NoFileExists(t, o.XlsxPath)
+}
+
+func assertWorkbook(t *testing.T, xlsxPath string) {
+	file, err := xlsx.OpenFile(xlsxPath)
+	require.NoError(t, err)
+	sheet := file.Sheet["2024-06-25"]
+	require.NotNil(t, sheet)
+
+	rows := sheet.Rows[1:]
+	require.Equal(t, 2, len(rows))
+
+	assert.Equal(t, "7", rows[0].Cells[0].Value)
+	assert.Equal(t, "200", rows[0].Cells[1].Value)
+	assert.Equal(t, "b", rows[0].Cells[2].Value)
+
+	assert.Equal(t, "12", rows[1].Cells[0].Value)
+	assert.Equal(t, "600", rows[1].Cells[1].Value)
+	assert.Equal(t, "a,c", rows[1].Cells[2].Value)
+}
+
+func TestProcessExclusionAppliesToHistory(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Comment: "", Amount: 20_050},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	r, err := st.Get("jar1", "b")
+	require.NoError(t, err)
+	assert.Equal(t, store.SourceUnknown, r.Source)
+	require.NoError(t, st.Close())
+
+	c, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(c.Exclusions))
+	c.Exclusions[0].Flat = 12
+	require.NoError(t, config.SetConfig(o.ConfigPath, *c))
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	st, err = store.Open(o.StorePath)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+	r, err = st.Get("jar1", "b")
+	require.NoError(t, err)
+	assert.Equal(t, store.SourceExclusion, r.Source)
+	assert.Equal(t, 12, r.Flat)
+
+	file, err := xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	rows := file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 1, len(rows))
+	assert.Equal(t, "12", rows[0].Cells[0].Value)
+	assert.Equal(t, "700.5", rows[0].Cells[1].Value)
+	assert.Equal(t, "a,b", rows[0].Cells[2].Value)
+}
+
+func TestProcessAddsExclusionsOnce(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "", Amount: 20_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Comment: "diesel", Amount: 30_000},
+		api.Transaction{ID: "c", Time: start.Add(3 * time.Hour).Unix(), Comment: "12", Amount: 50_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		err = Process(context.Background(), o)
+		require.NoError(t, err)
+	}
+
+	c, err := config.GetConfig(o.ConfigPath)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(c.Exclusions))
+	assert.Equal(t, "a", c.Exclusions[0].TransactionID)
+	assert.Equal(t, "b", c.Exclusions[1].TransactionID)
+	// a single backup of the config is rotated for both
+	assert.FileExists(t, safefile.BackupPath(o.ConfigPath, 1))
+	assert.NoFileExists(t, safefile.BackupPath(o.ConfigPath, 2))
+}
+
+func TestProcessLegacyWorkbook(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 15_075},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Comment: "12", Amount: 15_075},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	// a workbook written by the integer hryvnia version with a sheet of its own
+	file := xlsx.NewFile()
+	sheet, err := file.AddSheet("2024-06-25")
+	require.NoError(t, err)
+	for _, values := range [][]string{{"Flat", "Amount", "Transactions"}, {"12", "301", "a,b"}} {
+		row := sheet.AddRow()
+		for _, v := range values {
+			row.AddCell().Value = v
+		}
+	}
+	_, err = file.AddSheet("notes")
+	require.NoError(t, err)
+	require.NoError(t, file.Save(o.XlsxPath))
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	file, err = xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	assert.NotNil(t, file.Sheet["notes"])
+	rows := file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 1, len(rows))
+	assert.Equal(t, "12", rows[0].Cells[0].Value)
+	assert.Equal(t, "301.5", rows[0].Cells[1].Value)
+	assert.Contains(t, rows[0].Cells[1].NumFmt, "#,##0.00")
+	assert.Equal(t, "a,b", rows[0].Cells[2].Value)
+}
+
+func TestProcessInvalidToken(t *testing.T) {
+	server := newTestServer(t)
+
+	dir := t.TempDir()
+	confPath := filepath.Join(dir, "config.json")
+	err := config.SetConfig(confPath, config.Config{
+		XToken:     "invalid",
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), Options{
+		ConfigPath: confPath,
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	})
+	assert.ErrorIs(t, err, api.ErrUnauthorized)
+}
+
+func TestProcessCanceled(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1", api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000})
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+		Timeouts:   Timeouts{Fetch: 50 * time.Millisecond},
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = Process(ctx, o)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, server.Requests("/personal/client-info"))
+
+	// the statement waits for the rate limit longer than the fetch may take
+	limiter = api.NewRateLimiter(time.Hour)
+	require.NoError(t, limiter.Wait(context.Background(), limiterKey("statement", testToken)))
+	began := time.Now()
+	err = Process(context.Background(), o)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(began), 5*time.Second)
+	assert.NoFileExists(t, o.XlsxPath)
+}
+
+func TestProcessLogFields(t *testing.T) {
+	hook := logtest.NewGlobal()
+	t.Cleanup(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1", api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "thanks", Amount: 50_000})
+
+	dir := t.TempDir()
+	o := Options{
+		Tenant:     "logs",
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, Process(context.Background(), o))
+	require.NoError(t, Process(context.Background(), o))
+
+	runs := make(map[any]bool)
+	var invalid *log.Entry
+	for _, e := range hook.AllEntries() {
+		assert.Equal(t, "logs", e.Data[logging.Tenant], e.Message)
+		require.Contains(t, e.Data, logging.Run, e.Message)
+		runs[e.Data[logging.Run]] = true
+		if e.Message == "invalid comment" {
+			invalid = e
+		}
+	}
+	assert.Len(t, runs, 2)
+	require.NotNil(t, invalid)
+	assert.Equal(t, "Diesel", invalid.Data[logging.Campaign])
+	assert.Equal(t, "a", invalid.Data[logging.Transaction])
+	assert.Equal(t, "500.00 UAH", invalid.Data[logging.Amount])
+}
+
+func TestProcessLearnsCounterparties(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "кв 12", Amount: 50_000,
+			CounterIban: "UA213223130000026007233566001", CounterName: "Іван Петренко"},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: 20_000,
+			CounterIban: "UA213223130000026007233566001"},
+		api.Transaction{ID: "c", Time: start.Add(3 * time.Hour).Unix(), Amount: 10_000,
+			CounterName: "іван  петренко"},
+		// the same name pays for two flats
+		api.Transaction{ID: "d", Time: start.Add(4 * time.Hour).Unix(), Comment: "кв 7", Amount: 10_000,
+			CounterName: "Олена Коваль"},
+		api.Transaction{ID: "e", Time: start.Add(5 * time.Hour).Unix(), Comment: "кв 8", Amount: 10_000,
+			CounterName: "Олена Коваль"},
+		api.Transaction{ID: "f", Time: start.Add(6 * time.Hour).Unix(), Amount: 10_000,
+			CounterName: "Олена Коваль"},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	for id, expected := range map[string]store.Record{
+		"b": {Flat: 12, Source: store.SourceLearned},
+		"c": {Flat: 12, Source: store.SourceLearned},
+		"f": {Flat: 0, Source: store.SourceUnknown},
+	} {
+		r, err := st.Get("jar1", id)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Flat, r.Flat, id)
+		assert.Equal(t, expected.Source, r.Source, id)
+	}
+
+	counterparties, err := st.Counterparties()
+	require.NoError(t, err)
+	require.Equal(t, 3, len(counterparties))
+	assert.Equal(t, "iban:UA213223130000026007233566001", counterparties[0].Key)
+	assert.Equal(t, 12, counterparties[0].Flat)
+	assert.Equal(t, "a", counterparties[0].TransactionID)
+	assert.Equal(t, "name:олена коваль", counterparties[1].Key)
+	assert.True(t, counterparties[1].Ambiguous)
+	assert.Equal(t, "name:іван петренко", counterparties[2].Key)
+	assert.Equal(t, 12, counterparties[2].Flat)
+}
+
+func TestForgetCounterparty(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	const iban = "UA213223130000026007233566001"
+	server.AddTransactions("jar1",
+		// the payer mistyped the flat
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "кв 12", Amount: 50_000, CounterIban: iban},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: 20_000, CounterIban: iban},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	record := func(id string) *store.Record {
+		st, err := store.Open(o.StorePath)
+		require.NoError(t, err)
+		defer func(st *store.Store) {
+			_ = st.Close()
+		}(st)
+		r, err := st.Get("jar1", id)
+		require.NoError(t, err)
+		return r
+	}
+	assert.Equal(t, 12, record("b").Flat)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	found, err := st.ForgetCounterparty("iban:UA000")
+	require.NoError(t, err)
+	assert.False(t, found)
+	found, err = st.ForgetCounterparty(store.IBANKey(iban))
+	require.NoError(t, err)
+	assert.True(t, found)
+	require.NoError(t, st.Close())
+
+	// the payment it was learned from does not teach it again
+	err = RebuildWorkbook(context.Background(), o)
+	require.NoError(t, err)
+	assert.Equal(t, store.SourceUnknown, record("b").Source)
+
+	st, err = store.Open(o.StorePath)
+	require.NoError(t, err)
+	counterparties, err := st.Counterparties()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(counterparties))
+	assert.True(t, counterparties[0].Forgotten())
+	// forgotten before the next payment
+	counterparties[0].Since = time.Now().Add(-10 * time.Minute)
+	require.NoError(t, st.PutCounterparties(counterparties...))
+	require.NoError(t, st.Close())
+
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "c", Time: time.Now().Add(-2 * time.Minute).Unix(), Comment: "кв 21", Amount: 20_000, CounterIban: iban},
+		api.Transaction{ID: "d", Time: time.Now().Add(-time.Minute).Unix(), Amount: 20_000, CounterIban: iban},
+	)
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	r := record("d")
+	assert.Equal(t, 21, r.Flat)
+	assert.Equal(t, store.SourceLearned, r.Source)
+	assert.Equal(t, 12, record("a").Flat)
+
+	st, err = store.Open(o.StorePath)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+	counterparties, err = st.Counterparties()
+	require.NoError(t, err)
+	require.Equal(t, 1, len(counterparties))
+	assert.Equal(t, 21, counterparties[0].Flat)
+	assert.False(t, counterparties[0].Ambiguous)
+	assert.Equal(t, "c", counterparties[0].TransactionID)
+}
+
+func TestFetchFrom(t *testing.T) {
+	start := jarStartTime(t)
+	c := &config.Config{JarStart: testJarStart}
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "diesgen.db"))
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	// the campaign was never synced
+	from, err := fetchFrom(c, st, store.JarState{}, "jar1")
+	require.NoError(t, err)
+	assert.True(t, start.Equal(from))
+
+	last := start.Add(48 * time.Hour)
+	_, err = st.AddTransactions("jar1", []api.Transaction{
+		{ID: "a", Time: start.Add(time.Hour).Unix()},
+		{ID: "b", Time: last.Unix()},
+	})
+	require.NoError(t, err)
+
+	from, err = fetchFrom(c, st, store.JarState{Account: "jar1"}, "jar1")
+	require.NoError(t, err)
+	assert.True(t, last.Add(-refetchOverlap).Equal(from))
+
+	// transactions pushed after the fetched period do not move it
+	from, err = fetchFrom(c, st, store.JarState{Account: "jar1", FetchedTo: start.Add(24 * time.Hour)}, "jar1")
+	require.NoError(t, err)
+	assert.True(t, start.Add(24*time.Hour-refetchOverlap).Equal(from))
+
+	// windows without transactions are not fetched again
+	fetched := last.Add(60 * 24 * time.Hour)
+	from, err = fetchFrom(c, st, store.JarState{Account: "jar1", FetchedTo: fetched}, "jar1")
+	require.NoError(t, err)
+	assert.True(t, fetched.Add(-refetchOverlap).Equal(from))
+
+	// the campaign moved to another jar, it is fetched from the start
+	from, err = fetchFrom(c, st, store.JarState{Account: "jar1"}, "jar2")
+	require.NoError(t, err)
+	assert.True(t, start.Equal(from))
+}
+
+func TestProcessCampaigns(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+		// after the campaign end
+		api.Transaction{ID: "b", Time: start.Add(48 * time.Hour).Unix(), Comment: "7", Amount: 20_000},
+	)
+	server.AddTransactions("jar2",
+		api.Transaction{ID: "c", Time: start.Add(time.Hour).Unix(), Comment: "3", Amount: 10_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		Campaigns: []config.Campaign{
+			{Name: "winter", JarName: "Diesel", JarStart: testJarStart, JarEnd: "2024-06-26 11:00:00 +0300 EEST"},
+			{JarID: "jar2", JarStart: testJarStart, Sheet: "Starlink", Xlsx: "starlink.xlsx"},
+		},
+	})
+	require.NoError(t, err)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+	fetched := server.Requests("/personal/statement/jar1")
+
+	// the closed campaign is not fetched again
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+	assert.Equal(t, fetched, server.Requests("/personal/statement/jar1"))
+	assert.Equal(t, 2, server.Requests("/personal/client-info"))
+
+	file, err := xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	rows := file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 1, len(rows))
+	assert.Equal(t, "a", rows[0].Cells[2].Value)
+	assert.Nil(t, file.Sheet["Starlink"])
+
+	file, err = xlsx.OpenFile(filepath.Join(dir, "starlink.xlsx"))
+	require.NoError(t, err)
+	require.NotNil(t, file.Sheet["Starlink"])
+	rows = file.Sheet["Starlink"].Rows[1:]
+	require.Equal(t, 1, len(rows))
+	assert.Equal(t, "3", rows[0].Cells[0].Value)
+	assert.Equal(t, "c", rows[0].Cells[2].Value)
+
+	reports, err := BuildReport(o)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(reports))
+	assert.Equal(t, "winter", reports[0].Campaign)
+	assert.Equal(t, 1, reports[0].Transactions)
+	assert.Equal(t, "jar2", reports[1].Campaign)
+	assert.Equal(t, money.New(10_000, money.UAH), reports[1].Income)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"diesgen/api"
+	"diesgen/logging"
+	"diesgen/money"
+	"diesgen/store"
+	"time"
+)
+
+// reconcile compares the stored transactions of the jar with the balance reported by the bank
+// and stores the result when it changed. Differences above the config threshold are logged as errors.
+func reconcile(st *store.Store, cp campaign, j *api.Jar, now time.Time) error {
+	c := cp.config
+	records, err := st.Records(j.ID)
+	if err != nil {
+		return err
+	}
+
+	r := newReconciliation(j, records, now)
+	added, err := st.AddReconciliation(r)
+	if err != nil || !added {
+		return err
+	}
+
+	logf := cp.log.Infof
+	if !r.Discrepancy().IsZero() || len(r.Gaps) > 0 {
+		logf = cp.log.Warnf
+	}
+	if exceeds(r.Discrepancy(), c.ReconcileThreshold) {
+		logf = cp.log.Errorf
+	}
+	logf("jar balance %s, stored transactions sum up to %s, discrepancy %s",
+		r.JarBalance, r.Computed, r.Discrepancy())
+
+	for _, g := range r.Gaps {
+		l := cp.log.WithField(logging.Amount, g.Amount.String())
+		logf = l.Warnf
+		if exceeds(g.Amount, c.ReconcileThreshold) {
+			logf = l.Errorf
+		}
+		logf("%s missing between transactions %s and %s", g.Amount, g.After, g.Before)
+	}
+	return nil
+}
+
+// newReconciliation computes the balance from the stored records ordered by time. The balance before
+// the first record is taken from the balance the bank reports after it, transactions whose balance does
+// not follow from the previous one mark a gap of missing transactions.
+func newReconciliation(j *api.Jar, records []store.Record, now time.Time) store.Reconciliation {
+	r := store.Reconciliation{
+		Account:    j.ID,
+		Time:       now,
+		JarBalance: money.New(int64(j.Balance), j.CurrencyCode),
+		Computed:   money.New(int64(j.Balance), j.CurrencyCode),
+	}
+	if len(records) == 0 {
+		return r
+	}
+
+	first := records[0].Transaction
+	r.Computed = money.New(int64(first.Balance-first.Amount), j.CurrencyCode)
+	for i, rec := range records {
+		t := rec.Transaction
+		// amounts of the statement are in the jar currency
+		r.Computed.Amount += int64(t.Amount)
+		if i == 0 {
+			continue
+		}
+
+		prev := records[i-1].Transaction
+		if missing := t.Balance - (prev.Balance + t.Amount); missing != 0 {
+			r.Gaps = append(r.Gaps, store.Gap{
+				After:  prev.ID,
+				Before: t.ID,
+				Amount: money.New(int64(missing), j.CurrencyCode),
+			})
+		}
+	}
+	return r
+}
+
+func exceeds(m money.Money, threshold money.Money) bool {
+	return m.Amount > threshold.Amount || -m.Amount > threshold.Amount
+}
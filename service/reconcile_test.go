@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tealeg/xlsx"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProcessReconciles(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddJar(api.Jar{ID: "jar3", Title: "Generator", CurrencyCode: 980, Balance: 100_000})
+	server.AddTransactions("jar3",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000, Balance: 60_000},
+		api.Transaction{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: -20_000, Balance: 40_000},
+		// 300.00 UAH are missing before c
+		api.Transaction{ID: "c", Time: start.Add(3 * time.Hour).Unix(), Comment: "7", Amount: 10_000, Balance: 80_000},
+	)
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:             testToken,
+		APIBaseURL:         server.URL,
+		JarName:            "Generator",
+		JarStart:           testJarStart,
+		ReconcileThreshold: money.New(100, money.UAH),
+	})
+	require.NoError(t, err)
+
+	// the unchanged result of the second sync is not stored again
+	for i := 0; i < 2; i++ {
+		err = Process(context.Background(), o)
+		require.NoError(t, err)
+	}
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	reconciliations, err := st.Reconciliations("jar3")
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+
+	require.Equal(t, 1, len(reconciliations))
+	r := reconciliations[0]
+	assert.Equal(t, money.New(100_000, money.UAH), r.JarBalance)
+	assert.Equal(t, money.New(50_000, money.UAH), r.Computed)
+	assert.Equal(t, money.New(50_000, money.UAH), r.Discrepancy())
+	assert.Equal(t, []store.Gap{{After: "b", Before: "c", Amount: money.New(30_000, money.UAH)}}, r.Gaps)
+
+	file, err := xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	sheet := file.Sheet["2024-06-25 reconciliation"]
+	require.NotNil(t, sheet)
+	require.Equal(t, 2, len(sheet.Rows))
+	cells := sheet.Rows[1].Cells
+	assert.Equal(t, "1000", cells[1].Value)
+	assert.Equal(t, "500", cells[2].Value)
+	assert.Equal(t, "500", cells[3].Value)
+	assert.Equal(t, "300.00 UAH missing between b and c", cells[4].Value)
+}
+
+func TestNewReconciliation(t *testing.T) {
+	j := &api.Jar{ID: "jar1", CurrencyCode: 980, Balance: 70_000}
+	now := time.Now()
+
+	r := newReconciliation(j, nil, now)
+	assert.True(t, r.Discrepancy().IsZero())
+
+	r = newReconciliation(j, []store.Record{
+		{Transaction: api.Transaction{ID: "a", Amount: 50_000, Balance: 60_000}},
+		{Transaction: api.Transaction{ID: "b", Amount: 10_000, Balance: 70_000}},
+	}, now)
+	assert.Equal(t, money.New(70_000, money.UAH), r.Computed)
+	assert.True(t, r.Discrepancy().IsZero())
+	assert.Empty(t, r.Gaps)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"cmp"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/store"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// FlatTotal is the income of a flat, flat 0 collects the payments not attributed yet.
+type FlatTotal struct {
+	Flat         int
+	Transactions int
+	Paid         money.Money
+}
+
+// CategoryTotal is the spending of an expense category.
+type CategoryTotal struct {
+	Category     string
+	Transactions int
+	Spent        money.Money
+}
+
+// Report summarizes the stored transactions of a campaign.
+type Report struct {
+	Campaign     string
+	Transactions int
+	Income       money.Money
+	Withdrawn    money.Money
+	// Last is the time of the newest stored transaction
+	Last     time.Time
+	Flats    []FlatTotal
+	Expenses []CategoryTotal
+}
+
+// BuildReport summarizes the store for every campaign, flats are ordered by number
+// and expense categories by the amount spent.
+func BuildReport(o Options) ([]*Report, error) {
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return nil, err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	var reports []*Report
+	for _, cp := range campaigns(o.Logger(), o, c) {
+		r, err := campaignReport(st, cp)
+		if err != nil {
+			return nil, fmt.Errorf("campaign %s: %w", cp.id, err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, nil
+}
+
+func campaignReport(st *store.Store, cp campaign) (*Report, error) {
+	account, err := jarAccount(st, cp)
+	if err != nil {
+		return nil, err
+	}
+	records, err := cp.records(st, account)
+	if err != nil {
+		return nil, err
+	}
+	categories, err := rules.ExpensesFromConfig(cp.config)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Report{Campaign: cp.id, Transactions: len(records)}
+	flats := make(map[int]*FlatTotal)
+	expenses := make(map[string]*CategoryTotal)
+	for _, rec := range records {
+		t := rec.Transaction
+		r.Last = time.Unix(t.Time, 0)
+		if t.Amount < 0 {
+			name := categories.Category(t)
+			e, ok := expenses[name]
+			if !ok {
+				e = &CategoryTotal{Category: name}
+				expenses[name] = e
+			}
+			e.Transactions++
+			if r.Withdrawn, err = r.Withdrawn.Sub(t.Money()); err != nil {
+				return nil, fmt.Errorf("transaction %s: %w", t.ID, err)
+			}
+			if e.Spent, err = e.Spent.Sub(t.Money()); err != nil {
+				return nil, fmt.Errorf("transaction %s: %w", t.ID, err)
+			}
+			continue
+		}
+
+		f, ok := flats[rec.Flat]
+		if !ok {
+			f = &FlatTotal{Flat: rec.Flat}
+			flats[rec.Flat] = f
+		}
+		f.Transactions++
+		if r.Income, err = r.Income.Add(t.Money()); err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", t.ID, err)
+		}
+		if f.Paid, err = f.Paid.Add(t.Money()); err != nil {
+			return nil, fmt.Errorf("transaction %s: %w", t.ID, err)
+		}
+	}
+
+	for _, f := range flats {
+		r.Flats = append(r.Flats, *f)
+	}
+	slices.SortFunc(r.Flats, func(a, b FlatTotal) int {
+		return cmp.Compare(a.Flat, b.Flat)
+	})
+
+	for _, e := range expenses {
+		r.Expenses = append(r.Expenses, *e)
+	}
+	slices.SortFunc(r.Expenses, func(a, b CategoryTotal) int {
+		if c := cmp.Compare(b.Spent.Amount, a.Spent.Amount); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Category, b.Category)
+	})
+	return r, nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/money"
+	"diesgen/rules"
+	"diesgen/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	start := jarStartTime(t)
+	dir := t.TempDir()
+	o := Options{ConfigPath: filepath.Join(dir, "config.json"), StorePath: filepath.Join(dir, "diesgen.db")}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken: testToken,
+		Campaigns: []config.Campaign{
+			{JarName: "Diesel", JarStart: testJarStart, Expenses: []config.ExpenseCategory{{Name: "Oil", Pattern: "oil"}}},
+			{JarName: "Starlink", JarStart: testJarStart, Sheet: "Starlink"},
+		},
+	})
+	require.NoError(t, err)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	_, err = st.AddTransactions("jar1", []api.Transaction{
+		{ID: "a", Time: start.Add(time.Hour).Unix(), Amount: 50_000, CurrencyCode: 980},
+		{ID: "b", Time: start.Add(2 * time.Hour).Unix(), Amount: 20_000, CurrencyCode: 980},
+		{ID: "c", Time: start.Add(3 * time.Hour).Unix(), Amount: 10_000, CurrencyCode: 980},
+		{ID: "d", Time: start.Add(4 * time.Hour).Unix(), Amount: 5_000, CurrencyCode: 980},
+		{ID: "e", Time: start.Add(5 * time.Hour).Unix(), Amount: -30_000, CurrencyCode: 980, MCC: 5541},
+		{ID: "f", Time: start.Add(6 * time.Hour).Unix(), Amount: -5_000, CurrencyCode: 980, Comment: "oil"},
+		{ID: "g", Time: start.Add(7 * time.Hour).Unix(), Amount: -5_000, CurrencyCode: 980},
+		// before the jar start
+		{ID: "h", Time: start.Add(-time.Hour).Unix(), Amount: 10_000, CurrencyCode: 980},
+	})
+	require.NoError(t, err)
+	for id, flat := range map[string]int{"a": 12, "b": 7, "c": 12} {
+		r, err := st.Get("jar1", id)
+		require.NoError(t, err)
+		r.Flat, r.Source = flat, store.SourceComment
+		require.NoError(t, st.Put(*r))
+	}
+	require.NoError(t, st.SetJarState("Diesel", store.JarState{Account: "jar1"}))
+	require.NoError(t, st.Close())
+
+	// a campaign never synced fails the report
+	_, err = BuildReport(o)
+	require.ErrorIs(t, err, ErrNotSynced)
+	assert.Contains(t, err.Error(), "campaign Starlink")
+
+	st, err = store.Open(o.StorePath)
+	require.NoError(t, err)
+	require.NoError(t, st.SetJarState("Starlink", store.JarState{Account: "jar2"}))
+	require.NoError(t, st.Close())
+
+	reports, err := BuildReport(o)
+	require.NoError(t, err)
+	require.Equal(t, 2, len(reports))
+
+	r := reports[0]
+	assert.Equal(t, "Diesel", r.Campaign)
+	assert.Equal(t, 7, r.Transactions)
+	assert.True(t, start.Add(7*time.Hour).Equal(r.Last), r.Last)
+	assert.Equal(t, money.New(85_000, money.UAH), r.Income)
+	assert.Equal(t, money.New(40_000, money.UAH), r.Withdrawn)
+	// payments not attributed yet are flat 0
+	assert.Equal(t, []FlatTotal{
+		{Flat: 0, Transactions: 1, Paid: money.New(5_000, money.UAH)},
+		{Flat: 7, Transactions: 1, Paid: money.New(20_000, money.UAH)},
+		{Flat: 12, Transactions: 2, Paid: money.New(60_000, money.UAH)},
+	}, r.Flats)
+	// the most spent first, equal amounts by name
+	assert.Equal(t, []CategoryTotal{
+		{Category: "Fuel", Transactions: 1, Spent: money.New(30_000, money.UAH)},
+		{Category: "Oil", Transactions: 1, Spent: money.New(5_000, money.UAH)},
+		{Category: rules.OtherCategory, Transactions: 1, Spent: money.New(5_000, money.UAH)},
+	}, r.Expenses)
+
+	r = reports[1]
+	assert.Equal(t, "Starlink", r.Campaign)
+	assert.Zero(t, r.Transactions)
+	assert.True(t, r.Last.IsZero())
+	assert.Empty(t, r.Flats)
+	assert.Empty(t, r.Expenses)
+}
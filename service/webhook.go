@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/logging"
+	"diesgen/store"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// maxPushAhead bounds how far the time of a pushed transaction may be ahead of the clock.
+const maxPushAhead = 5 * time.Minute
+
+var (
+	// ErrUnknownJar is returned for pushed transactions of a jar no synced campaign collects into.
+	ErrUnknownJar = errors.New("jar of no campaign")
+	// ErrUnverifiable is returned for pushed transactions outside the period the next poll fetches,
+	// real ones are in the store already.
+	ErrUnverifiable = errors.New("outside the polled period")
+)
+
+// Push stores a transaction pushed by the monobank webhook, attributes it and regenerates the workbooks
+// of the campaigns it belongs to. It reports whether the transaction was new. Anyone knowing the webhook url
+// can push, so the transaction is kept only until the next poll fetches a statement without it. The jar
+// balance is reconciled by the next poll.
+func Push(ctx context.Context, o Options, account string, t api.Transaction) (bool, error) {
+	o.Timeouts = o.Timeouts.orDefault()
+	ctx, cancel := context.WithTimeout(ctx, o.Timeouts.Write)
+	defer cancel()
+	ctx, l := withRun(ctx, o)
+
+	c, err := config.GetConfig(o.ConfigPath)
+	if err != nil {
+		return false, err
+	}
+
+	st, err := store.Open(o.StorePath)
+	if err != nil {
+		return false, err
+	}
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+
+	at := time.Unix(t.Time, 0)
+	if at.After(time.Now().Add(maxPushAhead)) {
+		return false, fmt.Errorf("transaction %s at %s: %w", t.ID, at, ErrUnverifiable)
+	}
+
+	var matched []campaign
+	var fetched bool
+	for _, cp := range campaigns(l, o, c) {
+		state, err := st.JarState(cp.id)
+		if err != nil {
+			return false, err
+		}
+		start, end, err := cp.period()
+		if err != nil {
+			return false, err
+		}
+		if state.Account != account || !within(t, start, end) {
+			continue
+		}
+		// closed campaigns and periods before the refetch overlap are not fetched again
+		if (!end.IsZero() && !state.FetchedTo.Before(end)) || at.Before(state.FetchedTo.Add(-refetchOverlap)) {
+			fetched = true
+			continue
+		}
+		matched = append(matched, cp)
+	}
+	switch {
+	case len(matched) == 0 && fetched:
+		return false, fmt.Errorf("transaction %s at %s: %w", t.ID, at, ErrUnverifiable)
+	case len(matched) == 0:
+		return false, fmt.Errorf("transaction %s: %w %s", t.ID, ErrUnknownJar, account)
+	}
+
+	added, err := st.AddPushed(account, t)
+	if err != nil || !added {
+		return false, err
+	}
+
+	var unknown []config.Exclusion
+	for _, cp := range matched {
+		cp.log.WithFields(log.Fields{
+			logging.Transaction: t.ID,
+			logging.Amount:      t.Money().String(),
+		}).Info("transaction pushed")
+		transactionsProcessed.Inc(cp.tenant, cp.id)
+		exclusions, err := attribute(st, cp, account)
+		if err != nil {
+			return true, fmt.Errorf("campaign %s: %w", cp.id, err)
+		}
+		unknown = append(unknown, exclusions...)
+		err = rebuild(logging.WithEntry(ctx, cp.log), st, cp, account)
+		if err != nil {
+			return true, fmt.Errorf("campaign %s: %w", cp.id, err)
+		}
+	}
+	err = config.AddExclusions(o.ConfigPath, unknown...)
+	if err != nil {
+		return true, err
+	}
+	return true, observeExclusions(o.Tenant, o.ConfigPath, st)
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"diesgen/api"
+	"diesgen/config"
+	"diesgen/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tealeg/xlsx"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPush(t *testing.T) {
+	server := newTestServer(t)
+	start := jarStartTime(t)
+	server.AddTransactions("jar1",
+		api.Transaction{ID: "a", Time: start.Add(time.Hour).Unix(), Comment: "12", Amount: 50_000},
+	)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer receiver.Close()
+
+	dir := t.TempDir()
+	o := Options{
+		ConfigPath: filepath.Join(dir, "config.json"),
+		XlsxPath:   filepath.Join(dir, "diesgen.xlsx"),
+		StorePath:  filepath.Join(dir, "diesgen.db"),
+		Webhook:    true,
+	}
+	err := config.SetConfig(o.ConfigPath, config.Config{
+		XToken:     testToken,
+		APIBaseURL: server.URL,
+		WebhookURL: receiver.URL + "/webhook",
+		JarName:    "Diesel",
+		JarStart:   testJarStart,
+	})
+	require.NoError(t, err)
+
+	pushed := api.Transaction{ID: "b", Time: time.Now().Add(-time.Minute).Unix(), Comment: "кв 7", Amount: 20_000, CurrencyCode: 980}
+
+	// the jar is not known before the first sync
+	_, err = Push(context.Background(), o, "jar1", pushed)
+	assert.ErrorIs(t, err, ErrUnknownJar)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+	assert.Equal(t, receiver.URL+"/webhook", server.WebHookURL())
+
+	added, err := Push(context.Background(), o, "jar1", pushed)
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	// redelivered
+	added, err = Push(context.Background(), o, "jar1", pushed)
+	require.NoError(t, err)
+	assert.False(t, added)
+
+	_, err = Push(context.Background(), o, "jar2", api.Transaction{ID: "c", Time: time.Now().Unix(), Amount: 10_000})
+	assert.ErrorIs(t, err, ErrUnknownJar)
+
+	// the next poll would not verify them
+	_, err = Push(context.Background(), o, "jar1", api.Transaction{ID: "old", Time: start.Add(3 * time.Hour).Unix(), Amount: 10_000})
+	assert.ErrorIs(t, err, ErrUnverifiable)
+	_, err = Push(context.Background(), o, "jar1", api.Transaction{ID: "future", Time: time.Now().Add(time.Hour).Unix(), Amount: 10_000})
+	assert.ErrorIs(t, err, ErrUnverifiable)
+
+	file, err := xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	rows := file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 2, len(rows))
+	assert.Equal(t, "7", rows[0].Cells[0].Value)
+	assert.Equal(t, "b", rows[0].Cells[2].Value)
+	assert.Equal(t, "12", rows[1].Cells[0].Value)
+
+	// the bank confirms b, the forged d is dropped by the next poll
+	server.AddTransactions("jar1", pushed)
+	added, err = Push(context.Background(), o, "jar1", api.Transaction{ID: "d", Time: time.Now().Unix(), Comment: "12", Amount: 900_000})
+	require.NoError(t, err)
+	assert.True(t, added)
+
+	err = Process(context.Background(), o)
+	require.NoError(t, err)
+
+	st, err := store.Open(o.StorePath)
+	require.NoError(t, err)
+	defer func(st *store.Store) {
+		_ = st.Close()
+	}(st)
+	r, err := st.Get("jar1", "b")
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.False(t, r.Pushed)
+	r, err = st.Get("jar1", "d")
+	require.NoError(t, err)
+	assert.Nil(t, r)
+
+	file, err = xlsx.OpenFile(o.XlsxPath)
+	require.NoError(t, err)
+	rows = file.Sheet["2024-06-25"].Rows[1:]
+	require.Equal(t, 2, len(rows))
+	assert.Equal(t, "a", rows[1].Cells[2].Value)
+}
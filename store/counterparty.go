@@ -0,0 +1,91 @@
+package store
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"strings"
+	"time"
+)
+
+var counterpartiesBucket = []byte("counterparties")
+
+// Counterparty is a learned mapping of a payer IBAN or name to a flat.
+type Counterparty struct {
+	// Key is IBANKey or NameKey of the payer
+	Key  string `json:"key"`
+	Flat int    `json:"flat"`
+	// Ambiguous is set once the payer was resolved to different flats, it is not used for attribution then
+	Ambiguous bool `json:"ambiguous"`
+	// TransactionID is the transaction the mapping was learned from
+	TransactionID string    `json:"transactionID"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	// Since is set once the mapping was forgotten, it is learned again only from later transactions
+	Since time.Time `json:"since,omitempty"`
+}
+
+// Forgotten reports whether the mapping was forgotten and not learned again yet.
+func (c Counterparty) Forgotten() bool {
+	return c.Flat == 0 && !c.Ambiguous
+}
+
+func IBANKey(iban string) string {
+	return "iban:" + strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+}
+
+func NameKey(name string) string {
+	return "name:" + strings.Join(strings.Fields(strings.ToLower(name)), " ")
+}
+
+// Counterparties returns the learned mappings ordered by key.
+func (s *Store) Counterparties() ([]Counterparty, error) {
+	var counterparties []Counterparty
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(counterpartiesBucket)
+		return b.ForEach(func(_, v []byte) error {
+			var c Counterparty
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			counterparties = append(counterparties, c)
+			return nil
+		})
+	})
+	return counterparties, err
+}
+
+// PutCounterparties stores the mappings replacing existing ones with the same key.
+func (s *Store) PutCounterparties(counterparties ...Counterparty) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(counterpartiesBucket)
+		for _, c := range counterparties {
+			v, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(c.Key), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ForgetCounterparty drops the flat of the mapping, it is learned again from the payer transactions
+// resolved by the config after now. It reports whether the mapping existed.
+func (s *Store) ForgetCounterparty(key string) (bool, error) {
+	var found bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(counterpartiesBucket)
+		if b.Get([]byte(key)) == nil {
+			return nil
+		}
+		found = true
+		now := time.Now()
+		v, err := json.Marshal(Counterparty{Key: key, UpdatedAt: now, Since: now})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), v)
+	})
+	return found, err
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+	"encoding/json"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+var jarsBucket = []byte("jars")
+
+// JarState is the sync state of the jar of a campaign.
+type JarState struct {
+	// Account is the id of the jar, empty when the campaign was never synced
+	Account string `json:"account"`
+	// FetchedTo is the end of the last fetched statement period
+	FetchedTo time.Time `json:"fetchedTo"`
+}
+
+// SetJarState remembers the jar of the campaign so the workbook can be rebuilt without asking the bank.
+func (s *Store) SetJarState(campaign string, state JarState) error {
+	v, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jarsBucket).Put([]byte(campaign), v)
+	})
+}
+
+// JarState returns the sync state of the campaign, it is zero when the campaign was never synced.
+func (s *Store) JarState(campaign string) (JarState, error) {
+	var state JarState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(jarsBucket).Get([]byte(campaign))
+		if v == nil {
+			return nil
+		}
+		if json.Unmarshal(v, &state) != nil {
+			// stored as the bare account id before the fetch time was kept
+			state.Account = string(v)
+		}
+		return nil
+	})
+	return state, err
+}
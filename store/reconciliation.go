@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"diesgen/money"
+	"encoding/json"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"slices"
+	"time"
+)
+
+var reconciliationsBucket = []byte("reconciliations")
+
+// reconciliationKeyLayout keeps keys of an account ordered by time.
+const reconciliationKeyLayout = "20060102T150405.000000000"
+
+// Gap is a break in the balance chain of consecutive transactions, Amount is the sum of
+// the transactions missing between them.
+type Gap struct {
+	After  string      `json:"after"`
+	Before string      `json:"before"`
+	Amount money.Money `json:"amount"`
+}
+
+// Reconciliation compares the stored transactions of a jar with the balance reported by the bank.
+type Reconciliation struct {
+	Account    string      `json:"account"`
+	Time       time.Time   `json:"time"`
+	JarBalance money.Money `json:"jarBalance"`
+	// Computed is the balance before the first stored transaction plus every stored amount
+	Computed money.Money `json:"computed"`
+	Gaps     []Gap       `json:"gaps,omitempty"`
+}
+
+// Discrepancy returns the jar balance minus the computed one, both are in the jar currency.
+func (r Reconciliation) Discrepancy() money.Money {
+	return money.New(r.JarBalance.Amount-r.Computed.Amount, r.JarBalance.Currency)
+}
+
+// AddReconciliation stores r unless it equals the last reconciliation of the account,
+// so unchanged results of repeated syncs are kept once. It reports whether r was stored.
+func (s *Store) AddReconciliation(r Reconciliation) (bool, error) {
+	var added bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(reconciliationsBucket)
+		prefix := []byte(r.Account + "/")
+
+		c := b.Cursor()
+		var last []byte
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			last = v
+		}
+		if last != nil {
+			var prev Reconciliation
+			if err := json.Unmarshal(last, &prev); err != nil {
+				return err
+			}
+			if prev.JarBalance == r.JarBalance && prev.Computed == r.Computed && slices.Equal(prev.Gaps, r.Gaps) {
+				return nil
+			}
+		}
+
+		v, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		added = true
+		return b.Put([]byte(r.Account+"/"+r.Time.UTC().Format(reconciliationKeyLayout)), v)
+	})
+	return added, err
+}
+
+// Reconciliations returns the stored reconciliations of the account ordered by time.
+func (s *Store) Reconciliations(account string) ([]Reconciliation, error) {
+	var reconciliations []Reconciliation
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(reconciliationsBucket).Cursor()
+		prefix := []byte(account + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Reconciliation
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("reconciliation %s: %w", k, err)
+			}
+			reconciliations = append(reconciliations, r)
+		}
+		return nil
+	})
+	return reconciliations, err
+}
@@ -0,0 +1,274 @@
+// Package store keeps every fetched transaction and its attribution in a local bbolt database.
+// It is the source of truth the workbook is regenerated from.
+package store
+
+import (
+	"bytes"
+	"cmp"
+	"diesgen/api"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Source tells how the flat of a transaction was resolved.
+type Source string
+
+const (
+	SourceComment   Source = "comment"
+	SourceExclusion Source = "exclusion"
+	SourceRegistry  Source = "registry"
+	SourceLearned   Source = "learned"
+	SourceUnknown   Source = "unknown"
+)
+
+var transactionsBucket = []byte("transactions")
+
+// Record is a stored transaction of a jar with its resolved flat and card.
+type Record struct {
+	Account     string          `json:"account"`
+	Transaction api.Transaction `json:"transaction"`
+	Flat        int             `json:"flat"`
+	Card        string          `json:"card"`
+	Source      Source          `json:"source"`
+	Rule        string          `json:"rule,omitempty"`
+	FetchedAt   time.Time       `json:"fetchedAt"`
+	// Pushed marks a transaction received from the webhook that no statement has confirmed yet
+	Pushed bool `json:"pushed,omitempty"`
+}
+
+type Store struct {
+	db *bolt.DB
+}
+
+// Open opens or creates the database at path.
+func Open(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open store %s: %w", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{transactionsBucket, counterpartiesBucket, jarsBucket, reconciliationsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Snapshot copies the database at path to dir and opens the copy, the database is only read.
+// The copy is empty when there is no database at path.
+func Snapshot(path string, dir string) (*Store, error) {
+	target := filepath.Join(dir, filepath.Base(path))
+	_, err := os.Stat(path)
+	switch {
+	case err == nil:
+		db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 10 * time.Second, ReadOnly: true})
+		if err != nil {
+			return nil, fmt.Errorf("open store %s: %w", path, err)
+		}
+		err = db.View(func(tx *bolt.Tx) error {
+			return tx.CopyFile(target, 0600)
+		})
+		_ = db.Close()
+		if err != nil {
+			return nil, fmt.Errorf("copy store %s: %w", path, err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	return Open(target)
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// AddTransactions stores transactions of the account that are not stored yet as unresolved
+// records and refreshes the bank data of known ones keeping their attribution, pushed ones are
+// confirmed. It returns the number of new transactions.
+func (s *Store) AddTransactions(account string, transactions []api.Transaction) (int, error) {
+	var added int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(transactionsBucket)
+		for _, t := range transactions {
+			r := Record{Account: account, Source: SourceUnknown, FetchedAt: time.Now()}
+			if v := b.Get(key(account, t.ID)); v != nil {
+				if err := json.Unmarshal(v, &r); err != nil {
+					return err
+				}
+			} else {
+				added++
+			}
+			r.Transaction = t
+			r.Pushed = false
+
+			if err := put(b, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// AddPushed stores a transaction pushed by the webhook as an unresolved record until a statement
+// confirms it. Stored transactions are left as they are. It reports whether the transaction was new.
+func (s *Store) AddPushed(account string, t api.Transaction) (bool, error) {
+	var added bool
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(transactionsBucket)
+		if b.Get(key(account, t.ID)) != nil {
+			return nil
+		}
+		added = true
+		return put(b, Record{Account: account, Transaction: t, Source: SourceUnknown, FetchedAt: time.Now(), Pushed: true})
+	})
+	return added, err
+}
+
+// DropPushed deletes the unconfirmed pushed records of the account with a time in the range
+// and returns them, a statement of the range returned every real transaction.
+func (s *Store) DropPushed(account string, from time.Time, to time.Time) ([]Record, error) {
+	var dropped []Record
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(transactionsBucket)
+		c := b.Cursor()
+		prefix := []byte(account + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("record %s: %w", k, err)
+			}
+			if r.Pushed && r.Transaction.Time >= from.Unix() && r.Transaction.Time <= to.Unix() {
+				dropped = append(dropped, r)
+			}
+		}
+		for _, r := range dropped {
+			if err := b.Delete(key(account, r.Transaction.ID)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dropped, nil
+}
+
+// Put stores the records replacing existing ones with the same account and transaction id.
+func (s *Store) Put(records ...Record) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(transactionsBucket)
+		for _, r := range records {
+			if err := put(b, r); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Get returns the record of the transaction, nil when it is not stored.
+func (s *Store) Get(account string, transactionID string) (*Record, error) {
+	var r *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(transactionsBucket).Get(key(account, transactionID))
+		if v == nil {
+			return nil
+		}
+		r = &Record{}
+		return json.Unmarshal(v, r)
+	})
+	return r, err
+}
+
+// Find returns the record of the transaction in any account, nil when it is not stored.
+func (s *Store) Find(transactionID string) (*Record, error) {
+	var r *Record
+	suffix := []byte("/" + transactionID)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(transactionsBucket).ForEach(func(k, v []byte) error {
+			if r != nil || !bytes.HasSuffix(k, suffix) {
+				return nil
+			}
+			r = &Record{}
+			return json.Unmarshal(v, r)
+		})
+	})
+	return r, err
+}
+
+// LastTime returns the time of the newest stored transaction of the account, zero when there is none.
+func (s *Store) LastTime(account string) (time.Time, error) {
+	var last int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(transactionsBucket).Cursor()
+		prefix := []byte(account + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("record %s: %w", k, err)
+			}
+			last = max(last, r.Transaction.Time)
+		}
+		return nil
+	})
+	if err != nil || last == 0 {
+		return time.Time{}, err
+	}
+	return time.Unix(last, 0), nil
+}
+
+// Records returns the records of the account ordered by transaction time.
+func (s *Store) Records(account string) ([]Record, error) {
+	var records []Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(transactionsBucket).Cursor()
+		prefix := []byte(account + "/")
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var r Record
+			if err := json.Unmarshal(v, &r); err != nil {
+				return fmt.Errorf("record %s: %w", k, err)
+			}
+			records = append(records, r)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(records, func(a, b Record) int {
+		if c := cmp.Compare(a.Transaction.Time, b.Transaction.Time); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Transaction.ID, b.Transaction.ID)
+	})
+	return records, nil
+}
+
+func put(b *bolt.Bucket, r Record) error {
+	v, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(r.Account, r.Transaction.ID), v)
+}
+
+func key(account string, transactionID string) []byte {
+	return []byte(account + "/" + transactionID)
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"diesgen/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 6, 25, 11, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T) *Store {
+	st, err := Open(filepath.Join(t.TempDir(), "diesgen.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = st.Close()
+	})
+	return st
+}
+
+func transaction(id string, at time.Duration, amount int) api.Transaction {
+	return api.Transaction{ID: id, Time: start.Add(at).Unix(), Amount: amount, CurrencyCode: 980}
+}
+
+func TestOpenCreatesBuckets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "diesgen.db")
+	st, err := Open(path)
+	require.NoError(t, err)
+	_, err = st.AddTransactions("jar1", []api.Transaction{transaction("a", 0, 100)})
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+
+	// an existing database keeps its records
+	st, err = Open(path)
+	require.NoError(t, err)
+	defer func(st *Store) {
+		_ = st.Close()
+	}(st)
+	err = st.db.View(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{transactionsBucket, counterpartiesBucket, jarsBucket, reconciliationsBucket} {
+			assert.NotNil(t, tx.Bucket(name), string(name))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	records, err := st.Records("jar1")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(records))
+}
+
+func TestAddTransactionsDeduplicates(t *testing.T) {
+	st := openTestStore(t)
+
+	added, err := st.AddTransactions("jar1", []api.Transaction{transaction("b", time.Hour, 200), transaction("a", 0, 100)})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+
+	// the attribution survives a refetch, the bank data is refreshed
+	r, err := st.Get("jar1", "a")
+	require.NoError(t, err)
+	r.Flat = 12
+	r.Source = SourceComment
+	require.NoError(t, st.Put(*r))
+
+	a := transaction("a", 0, 100)
+	a.Comment = "12"
+	added, err = st.AddTransactions("jar1", []api.Transaction{a, transaction("c", 2*time.Hour, 300)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	// the same id in another jar is another transaction
+	added, err = st.AddTransactions("jar2", []api.Transaction{transaction("a", 0, 100)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	records, err := st.Records("jar1")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(records))
+	assert.Equal(t, "a", records[0].Transaction.ID)
+	assert.Equal(t, "12", records[0].Transaction.Comment)
+	assert.Equal(t, 12, records[0].Flat)
+	assert.Equal(t, SourceComment, records[0].Source)
+	assert.Equal(t, "b", records[1].Transaction.ID)
+	assert.Equal(t, SourceUnknown, records[1].Source)
+	assert.Equal(t, "c", records[2].Transaction.ID)
+}
+
+func TestDropPushed(t *testing.T) {
+	st := openTestStore(t)
+
+	for _, tr := range []api.Transaction{
+		transaction("early", time.Hour, 100),
+		transaction("confirmed", 2*time.Hour, 200),
+		transaction("missing", 3*time.Hour, 300),
+		transaction("later", 5*time.Hour, 400),
+	} {
+		added, err := st.AddPushed("jar1", tr)
+		require.NoError(t, err)
+		assert.True(t, added, tr.ID)
+	}
+	added, err := st.AddPushed("jar1", transaction("missing", 3*time.Hour, 300))
+	require.NoError(t, err)
+	assert.False(t, added)
+	_, err = st.AddPushed("jar2", transaction("other", 3*time.Hour, 300))
+	require.NoError(t, err)
+
+	// the statement confirms one of the pushed transactions
+	_, err = st.AddTransactions("jar1", []api.Transaction{transaction("confirmed", 2*time.Hour, 200)})
+	require.NoError(t, err)
+
+	dropped, err := st.DropPushed("jar1", start.Add(2*time.Hour), start.Add(4*time.Hour))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(dropped))
+	assert.Equal(t, "missing", dropped[0].Transaction.ID)
+
+	records, err := st.Records("jar1")
+	require.NoError(t, err)
+	var ids []string
+	for _, r := range records {
+		ids = append(ids, r.Transaction.ID)
+	}
+	assert.Equal(t, []string{"early", "confirmed", "later"}, ids)
+	assert.True(t, records[0].Pushed)
+	assert.False(t, records[1].Pushed)
+
+	other, err := st.Records("jar2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(other))
+}
+
+func TestLastTime(t *testing.T) {
+	st := openTestStore(t)
+
+	last, err := st.LastTime("jar1")
+	require.NoError(t, err)
+	assert.True(t, last.IsZero())
+
+	_, err = st.AddTransactions("jar1", []api.Transaction{transaction("b", 3*time.Hour, 200), transaction("a", time.Hour, 100)})
+	require.NoError(t, err)
+	_, err = st.AddTransactions("jar2", []api.Transaction{transaction("c", 5*time.Hour, 300)})
+	require.NoError(t, err)
+
+	last, err = st.LastTime("jar1")
+	require.NoError(t, err)
+	assert.True(t, start.Add(3*time.Hour).Equal(last), last)
+}
+
+func TestJarState(t *testing.T) {
+	st := openTestStore(t)
+
+	state, err := st.JarState("Diesel")
+	require.NoError(t, err)
+	assert.Equal(t, JarState{}, state)
+
+	fetchedTo := start.Add(24 * time.Hour)
+	require.NoError(t, st.SetJarState("Diesel", JarState{Account: "jar1", FetchedTo: fetchedTo}))
+	state, err = st.JarState("Diesel")
+	require.NoError(t, err)
+	assert.Equal(t, "jar1", state.Account)
+	assert.True(t, fetchedTo.Equal(state.FetchedTo))
+
+	// stored as the bare account id before the fetch time was kept
+	err = st.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(jarsBucket).Put([]byte("Starlink"), []byte("jar2"))
+	})
+	require.NoError(t, err)
+	state, err = st.JarState("Starlink")
+	require.NoError(t, err)
+	assert.Equal(t, JarState{Account: "jar2"}, state)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "diesgen.db")
+
+	// nothing is created for a missing database
+	st, err := Snapshot(path, t.TempDir())
+	require.NoError(t, err)
+	records, err := st.Records("jar1")
+	require.NoError(t, err)
+	assert.Empty(t, records)
+	require.NoError(t, st.Close())
+	assert.NoFileExists(t, path)
+
+	st, err = Open(path)
+	require.NoError(t, err)
+	_, err = st.AddTransactions("jar1", []api.Transaction{transaction("a", 0, 100)})
+	require.NoError(t, err)
+	require.NoError(t, st.Close())
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	st, err = Snapshot(path, t.TempDir())
+	require.NoError(t, err)
+	_, err = st.AddTransactions("jar1", []api.Transaction{transaction("b", time.Hour, 200)})
+	require.NoError(t, err)
+	records, err = st.Records("jar1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, len(records))
+	require.NoError(t, st.Close())
+
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,69 @@
+//go:build unix
+
+package main
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"text/template"
+)
+
+var unitTemplate = template.Must(template.New("unit").Parse(`[Unit]
+Description={{.Name}} monobank jar to xlsx synchronizer
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=notify
+ExecStart={{.Executable}} run {{if .TenantsPath}}-tenants {{.TenantsPath}}{{else}}-config {{.ConfigPath}} -xlsx {{.XlsxPath}} -store {{.StorePath}}{{end}} -log {{.LogPath}}
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=10
+TimeoutStopSec=30
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+func writeSystemdUnit(w io.Writer, p unitParams) error {
+	return unitTemplate.Execute(w, p)
+}
+
+func notifyState(s State) string {
+	switch s {
+	case StateRunning:
+		return "READY=1\nSTATUS=running"
+	case StatePaused:
+		return "STATUS=paused"
+	case StateStopPending:
+		return "STOPPING=1"
+	default:
+		return "STATUS=" + strings.ToLower(s.String())
+	}
+}
+
+// sdNotify sends state to the systemd notification socket.
+// It does nothing when the process is not started by systemd with Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// abstract namespace sockets are passed with a leading '@'
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer func(conn *net.UnixConn) {
+		_ = conn.Close()
+	}(conn)
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
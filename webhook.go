@@ -0,0 +1,137 @@
+package main
+
+import (
+	"diesgen/api"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	// webhookSilence is how long the webhook may be silent before the jars are polled on their
+	// schedule again, they are polled every webhookSilence while it pushes transactions.
+	webhookSilence = 10 * time.Minute
+	// maxWebhookBody limits the size of a pushed event.
+	maxWebhookBody = 64 << 10
+	// webhookQueue is the number of pushed events waiting for a tenant, more are left to polling.
+	webhookQueue = 64
+	// maxSeenEvents bounds the transaction ids remembered to drop redelivered events.
+	maxSeenEvents = 1000
+)
+
+// startWebhook starts the webhook receiver on WebhookAddr. It returns nil when the receiver
+// is not configured or can not listen, the jars are polled as usual then.
+func (m *DiesGenService) startWebhook() *http.Server {
+	if m.WebhookAddr == "" {
+		return nil
+	}
+
+	server := serve("webhook", m.WebhookAddr, m)
+	if server == nil {
+		return nil
+	}
+	for _, t := range m.tenants {
+		t.Webhook = true
+	}
+	return server
+}
+
+// ServeHTTP receives the webhook of the tenant whose webhookUrl has the request path. Monobank
+// checks the url with a GET request when it is registered and pushes a StatementItem event for
+// every transaction of the token, redelivering it until it is answered with 200.
+func (m *DiesGenService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	t := m.webhookTenant(r.URL.Path)
+	if t == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.WriteHeader(http.StatusOK)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var e api.WebHookEvent
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&e)
+	if err != nil {
+		t.log.Warnf("invalid webhook event: %v", err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case e.Type != api.StatementItemEvent:
+		t.log.Infof("webhook event %s ignored", e.Type)
+	case e.Data.Account == "" || e.Data.StatementItem.ID == "" || e.Data.StatementItem.Time == 0:
+		t.log.Warnf("incomplete webhook event of transaction %q ignored", e.Data.StatementItem.ID)
+	default:
+		t.enqueue(e)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (m *DiesGenService) webhookTenant(path string) *tenant {
+	for _, t := range m.tenants {
+		if p := t.webhookPath(); p != "" && p == path {
+			return t
+		}
+	}
+	return nil
+}
+
+// setWebhook remembers the path of the webhook url the receiver serves the tenant on.
+func (t *tenant) setWebhook(webhookURL string) {
+	path := ""
+	if u, err := url.Parse(webhookURL); err == nil && webhookURL != "" {
+		path = u.Path
+		if path == "" {
+			path = "/"
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.webhook = path
+}
+
+func (t *tenant) webhookPath() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.webhook
+}
+
+// enqueue passes the event to the loop of the tenant unless it was received before.
+// Events are dropped when the loop is behind, the next poll fetches them.
+func (t *tenant) enqueue(e api.WebHookEvent) {
+	id := e.Data.StatementItem.ID
+
+	t.mu.Lock()
+	if t.seen[id] {
+		t.mu.Unlock()
+		return
+	}
+	if len(t.seen) >= maxSeenEvents {
+		clear(t.seen)
+	}
+	t.seen[id] = true
+	t.mu.Unlock()
+
+	select {
+	case t.events <- e:
+	default:
+		t.log.Warnf("webhook queue is full, transaction %s is left to polling", id)
+	}
+}
+
+// pollDue reports whether the jars are polled on a tick, they are polled every webhookSilence
+// only while the webhook pushes transactions.
+func (t *tenant) pollDue(now time.Time) bool {
+	return now.Sub(t.lastEvent) >= webhookSilence || now.Sub(t.lastPoll) >= webhookSilence
+}